@@ -4,6 +4,8 @@
 package apiserver
 
 import (
+	"sync/atomic"
+
 	"github.com/juju/errors"
 
 	apiservererrors "github.com/juju/juju/apiserver/errors"
@@ -28,10 +30,56 @@ type Queue interface {
 	Enqueue(queue.Operation) error
 }
 
+// maxForwardingHops bounds how many times an ApplyLease request may be
+// forwarded from one server to another before it is rejected, so that
+// leadership churn can never turn a single request into an infinite loop.
+const maxForwardingHops = 2
+
+// RaftMediatorMetrics is a point-in-time snapshot of a raftMediator's
+// forwarding activity.
+type RaftMediatorMetrics struct {
+	// Local is the number of ApplyLease calls this mediator applied
+	// itself, because it was the raft leader.
+	Local uint64
+	// Forwarded is the number of ApplyLease calls this mediator
+	// forwarded on to another server.
+	Forwarded uint64
+}
+
 // raftMediator encapsulates raft related capabilities to the facades.
 type raftMediator struct {
 	queue  Queue
 	logger Logger
+
+	// forwarder is used to resubmit a command to another raft server
+	// when this one isn't the leader. It is nil when forwarding is
+	// disabled, in which case ApplyLease falls back to returning a
+	// NotLeaderError to the caller, as it always used to.
+	forwarder LeaderForwarder
+
+	local     uint64
+	forwarded uint64
+}
+
+// NewRaftMediator returns a raftMediator that enqueues commands onto
+// queue. If forwarder is non-nil, a NotLeaderError from queue causes the
+// command to be forwarded to the identified leader instead of being
+// returned to the caller.
+func NewRaftMediator(queue Queue, logger Logger, forwarder LeaderForwarder) *raftMediator {
+	return &raftMediator{
+		queue:     queue,
+		logger:    logger,
+		forwarder: forwarder,
+	}
+}
+
+// Metrics returns a snapshot of how many ApplyLease calls this mediator
+// has applied locally versus forwarded on to another server.
+func (m *raftMediator) Metrics() RaftMediatorMetrics {
+	return RaftMediatorMetrics{
+		Local:     atomic.LoadUint64(&m.local),
+		Forwarded: atomic.LoadUint64(&m.forwarded),
+	}
 }
 
 // ApplyLease attempts to apply the command on to the raft FSM. It only takes a
@@ -40,6 +88,14 @@ type raftMediator struct {
 // caller and a ErrEnqueueDeadlineExceeded will be sent. It's up to the caller
 // to retry or drop depending on how the retry algorithm is implemented.
 func (m *raftMediator) ApplyLease(cmd []byte) error {
+	return m.applyLease(cmd, 0)
+}
+
+// applyLease is the implementation of ApplyLease, plus the hop count of the
+// request so forwarding can refuse to keep chasing leadership changes
+// forever. hops is 0 for a request originating at this server, and is
+// incremented by one on every forward.
+func (m *raftMediator) applyLease(cmd []byte, hops int) error {
 	if m.logger.IsTraceEnabled() {
 		m.logger.Tracef("Applying Lease with command %s", string(cmd))
 	}
@@ -50,6 +106,7 @@ func (m *raftMediator) ApplyLease(cmd []byte) error {
 
 	switch {
 	case err == nil:
+		atomic.AddUint64(&m.local, 1)
 		return nil
 
 	case raft.IsNotLeaderError(err):
@@ -57,7 +114,7 @@ func (m *raftMediator) ApplyLease(cmd []byte) error {
 		// the correct boundaries.
 		leaderErr := errors.Cause(err).(*raft.NotLeaderError)
 		m.logger.Tracef("Not currently the leader, go to %v %v", leaderErr.ServerAddress(), leaderErr.ServerID())
-		return apiservererrors.NewNotLeaderError(leaderErr.ServerAddress(), leaderErr.ServerID())
+		return m.forward(leaderErr, cmd, hops)
 
 	case queue.IsDeadlineExceeded(err):
 		// If the deadline is exceeded, get original callee to handle the
@@ -67,3 +124,35 @@ func (m *raftMediator) ApplyLease(cmd []byte) error {
 	}
 	return errors.Trace(err)
 }
+
+// forward resubmits cmd to the leader identified by leaderErr, if
+// forwarding is enabled and hops hasn't already reached
+// maxForwardingHops. Otherwise it falls back to today's behaviour of
+// reporting the redirect to the caller.
+func (m *raftMediator) forward(leaderErr *raft.NotLeaderError, cmd []byte, hops int) error {
+	if m.forwarder == nil || hops >= maxForwardingHops {
+		return apiservererrors.NewNotLeaderError(leaderErr.ServerAddress(), leaderErr.ServerID())
+	}
+
+	m.logger.Tracef("forwarding command to %v %v, hop %d", leaderErr.ServerAddress(), leaderErr.ServerID(), hops)
+
+	err := m.forwarder.ApplyLease(leaderErr.ServerID(), leaderErr.ServerAddress(), cmd, hops+1)
+
+	switch {
+	case err == nil:
+		atomic.AddUint64(&m.forwarded, 1)
+		return nil
+
+	case raft.IsNotLeaderError(err):
+		leaderErr, ok := errors.Cause(err).(*raft.NotLeaderError)
+		if !ok {
+			return errors.Trace(err)
+		}
+		return m.forward(leaderErr, cmd, hops+1)
+
+	case queue.IsDeadlineExceeded(err):
+		return apiservererrors.NewDeadlineExceededError(err.Error())
+
+	}
+	return errors.Trace(err)
+}
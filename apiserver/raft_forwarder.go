@@ -0,0 +1,132 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// maxForwardingFailures is how many consecutive failures a pooled
+// connection to a server may accumulate before forwardingConnPool evicts
+// it and dials afresh next time that server is needed.
+const maxForwardingFailures = 3
+
+// LeaderForwarder resubmits a command to another raft server, for use
+// when the local server has just learned it isn't the leader.
+type LeaderForwarder interface {
+	// ApplyLease applies cmd via the server identified by serverID,
+	// reachable at serverAddress, carrying hops forward so the remote
+	// server can keep enforcing maxForwardingHops.
+	ApplyLease(serverID, serverAddress string, cmd []byte, hops int) error
+}
+
+// DialLeader opens (or re-uses) whatever's needed to forward an
+// ApplyLease call to the server at serverAddress.
+type DialLeader func(serverAddress string) (LeaderApplier, error)
+
+// LeaderApplier is the thin slice of an API connection that forwarding
+// needs: the ability to ask the server on the other end to apply a
+// command itself.
+type LeaderApplier interface {
+	// ApplyLease asks the server on the other end of the connection to
+	// apply cmd, having already been forwarded hops times.
+	ApplyLease(cmd []byte, hops int) error
+
+	// Ping reports whether the underlying connection is still usable. A
+	// cached connection is health-checked with Ping before being reused,
+	// so a server that restarted or dropped the connection out from
+	// under us gets redialed instead of failing maxForwardingFailures
+	// times first.
+	Ping() error
+
+	// Close releases the underlying connection.
+	Close() error
+}
+
+// forwardingConnPool is a LeaderForwarder that caches one connection per
+// server ID, dialing lazily, health-checking a cached connection with
+// Ping before reuse, and evicting a connection once it has failed
+// maxForwardingFailures times in a row.
+type forwardingConnPool struct {
+	dial DialLeader
+
+	mu    sync.Mutex
+	conns map[string]*pooledConn
+}
+
+// pooledConn is a cached connection to a single server, along with how
+// many times in a row it has failed to forward a request.
+type pooledConn struct {
+	applier  LeaderApplier
+	failures int
+}
+
+// NewForwardingConnPool returns a LeaderForwarder that dials new
+// connections with dial, reusing them across calls to the same server.
+func NewForwardingConnPool(dial DialLeader) *forwardingConnPool {
+	return &forwardingConnPool{
+		dial:  dial,
+		conns: make(map[string]*pooledConn),
+	}
+}
+
+// ApplyLease is part of the LeaderForwarder interface.
+func (p *forwardingConnPool) ApplyLease(serverID, serverAddress string, cmd []byte, hops int) error {
+	conn, err := p.connFor(serverID, serverAddress)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	err = conn.applier.ApplyLease(cmd, hops)
+	p.recordResult(serverID, conn, err)
+	return errors.Trace(err)
+}
+
+// connFor returns the pooled connection for serverID, dialing and
+// caching a new one if there isn't one already, or if the cached one
+// fails a health check.
+func (p *forwardingConnPool) connFor(serverID, serverAddress string) (*pooledConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if conn, ok := p.conns[serverID]; ok {
+		if err := conn.applier.Ping(); err == nil {
+			return conn, nil
+		}
+		_ = conn.applier.Close()
+		delete(p.conns, serverID)
+	}
+
+	applier, err := p.dial(serverAddress)
+	if err != nil {
+		return nil, errors.Annotatef(err, "dialing raft leader %v", serverAddress)
+	}
+	conn := &pooledConn{applier: applier}
+	p.conns[serverID] = conn
+	return conn, nil
+}
+
+// recordResult clears conn's failure count on success, or evicts it from
+// the pool once it has failed maxForwardingFailures times in a row, so
+// the next request dials a fresh connection.
+func (p *forwardingConnPool) recordResult(serverID string, conn *pooledConn, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err == nil {
+		conn.failures = 0
+		return
+	}
+
+	conn.failures++
+	if conn.failures < maxForwardingFailures {
+		return
+	}
+	if p.conns[serverID] == conn {
+		delete(p.conns, serverID)
+	}
+	_ = conn.applier.Close()
+}
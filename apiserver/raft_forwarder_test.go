@@ -0,0 +1,149 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type ForwardingConnPoolSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&ForwardingConnPoolSuite{})
+
+// fakeApplier is a LeaderApplier whose ApplyLease/Ping results are
+// scripted, so tests can drive forwardingConnPool without a real
+// connection.
+type fakeApplier struct {
+	applyErrs []error
+	applied   []int // hops recorded for each ApplyLease call
+	pingErr   error
+	closed    bool
+}
+
+func (f *fakeApplier) ApplyLease(cmd []byte, hops int) error {
+	f.applied = append(f.applied, hops)
+	if len(f.applyErrs) == 0 {
+		return nil
+	}
+	err := f.applyErrs[0]
+	f.applyErrs = f.applyErrs[1:]
+	return err
+}
+
+func (f *fakeApplier) Ping() error {
+	return f.pingErr
+}
+
+func (f *fakeApplier) Close() error {
+	f.closed = true
+	return nil
+}
+
+func (s *ForwardingConnPoolSuite) TestApplyLeaseDialsAndReusesConnection(c *gc.C) {
+	applier := &fakeApplier{}
+	dialCount := 0
+	pool := NewForwardingConnPool(func(serverAddress string) (LeaderApplier, error) {
+		dialCount++
+		c.Check(serverAddress, gc.Equals, "10.0.0.1")
+		return applier, nil
+	})
+
+	err := pool.ApplyLease("server-1", "10.0.0.1", []byte("cmd"), 1)
+	c.Assert(err, jc.ErrorIsNil)
+	err = pool.ApplyLease("server-1", "10.0.0.1", []byte("cmd"), 2)
+	c.Assert(err, jc.ErrorIsNil)
+
+	c.Check(dialCount, gc.Equals, 1)
+	c.Check(applier.applied, gc.DeepEquals, []int{1, 2})
+}
+
+func (s *ForwardingConnPoolSuite) TestApplyLeaseDialError(c *gc.C) {
+	pool := NewForwardingConnPool(func(serverAddress string) (LeaderApplier, error) {
+		return nil, errors.New("boom")
+	})
+
+	err := pool.ApplyLease("server-1", "10.0.0.1", []byte("cmd"), 0)
+	c.Assert(err, gc.ErrorMatches, ".*boom.*")
+}
+
+func (s *ForwardingConnPoolSuite) TestApplyLeaseEvictsAfterMaxFailures(c *gc.C) {
+	applier := &fakeApplier{
+		applyErrs: []error{
+			errors.New("fail 1"),
+			errors.New("fail 2"),
+			errors.New("fail 3"),
+		},
+	}
+	dialCount := 0
+	pool := NewForwardingConnPool(func(serverAddress string) (LeaderApplier, error) {
+		dialCount++
+		return applier, nil
+	})
+
+	for i := 0; i < maxForwardingFailures; i++ {
+		err := pool.ApplyLease("server-1", "10.0.0.1", []byte("cmd"), 0)
+		c.Assert(err, gc.NotNil)
+	}
+	c.Check(dialCount, gc.Equals, 1)
+	c.Check(applier.closed, gc.Equals, true)
+
+	// The next call redials, since the failed connection was evicted.
+	err := pool.ApplyLease("server-1", "10.0.0.1", []byte("cmd"), 0)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(dialCount, gc.Equals, 2)
+}
+
+func (s *ForwardingConnPoolSuite) TestApplyLeaseSuccessResetsFailureCount(c *gc.C) {
+	applier := &fakeApplier{
+		applyErrs: []error{errors.New("fail 1"), errors.New("fail 2")},
+	}
+	dialCount := 0
+	pool := NewForwardingConnPool(func(serverAddress string) (LeaderApplier, error) {
+		dialCount++
+		return applier, nil
+	})
+
+	for i := 0; i < maxForwardingFailures-1; i++ {
+		err := pool.ApplyLease("server-1", "10.0.0.1", []byte("cmd"), 0)
+		c.Assert(err, gc.NotNil)
+	}
+	// A success in between resets the failure count, so it takes
+	// maxForwardingFailures more failures to evict, not just one more.
+	err := pool.ApplyLease("server-1", "10.0.0.1", []byte("cmd"), 0)
+	c.Assert(err, jc.ErrorIsNil)
+
+	applier.applyErrs = []error{errors.New("fail 3")}
+	err = pool.ApplyLease("server-1", "10.0.0.1", []byte("cmd"), 0)
+	c.Assert(err, gc.NotNil)
+	c.Check(dialCount, gc.Equals, 1)
+	c.Check(applier.closed, gc.Equals, false)
+}
+
+func (s *ForwardingConnPoolSuite) TestUnhealthyConnectionIsRedialed(c *gc.C) {
+	bad := &fakeApplier{pingErr: errors.New("connection gone")}
+	good := &fakeApplier{}
+	dialCount := 0
+	pool := NewForwardingConnPool(func(serverAddress string) (LeaderApplier, error) {
+		dialCount++
+		if dialCount == 1 {
+			return bad, nil
+		}
+		return good, nil
+	})
+
+	err := pool.ApplyLease("server-1", "10.0.0.1", []byte("cmd"), 0)
+	c.Assert(err, jc.ErrorIsNil)
+
+	err = pool.ApplyLease("server-1", "10.0.0.1", []byte("cmd"), 0)
+	c.Assert(err, jc.ErrorIsNil)
+
+	c.Check(dialCount, gc.Equals, 2)
+	c.Check(bad.closed, gc.Equals, true)
+	c.Check(good.applied, gc.DeepEquals, []int{0})
+}
@@ -0,0 +1,129 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package apiserver
+
+import (
+	"github.com/juju/errors"
+	"github.com/juju/loggo"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/juju/core/raft/queue"
+	"github.com/juju/juju/worker/raft"
+)
+
+type RaftMediatorSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&RaftMediatorSuite{})
+
+func mediatorLogger() Logger {
+	return loggo.GetLogger("apiserver.raft.test")
+}
+
+// fakeQueue lets tests script what Enqueue returns without a real raft
+// FSM behind it.
+type fakeQueue struct {
+	errs     []error
+	enqueued [][]byte
+}
+
+func (q *fakeQueue) Enqueue(op queue.Operation) error {
+	q.enqueued = append(q.enqueued, op.Commands...)
+	if len(q.errs) == 0 {
+		return nil
+	}
+	err := q.errs[0]
+	q.errs = q.errs[1:]
+	return err
+}
+
+// fakeForwarder lets tests script what forwarding an ApplyLease call
+// returns, and records the hop count it was called with.
+type fakeForwarder struct {
+	errs []error
+	hops []int
+}
+
+func (f *fakeForwarder) ApplyLease(serverID, serverAddress string, cmd []byte, hops int) error {
+	f.hops = append(f.hops, hops)
+	if len(f.errs) == 0 {
+		return nil
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	return err
+}
+
+func (s *RaftMediatorSuite) TestApplyLeaseLocalSuccess(c *gc.C) {
+	q := &fakeQueue{}
+	m := NewRaftMediator(q, mediatorLogger(), nil)
+
+	err := m.ApplyLease([]byte("cmd"))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(q.enqueued, gc.DeepEquals, [][]byte{[]byte("cmd")})
+	c.Check(m.Metrics(), gc.Equals, RaftMediatorMetrics{Local: 1})
+}
+
+func (s *RaftMediatorSuite) TestApplyLeaseForwardsOnNotLeader(c *gc.C) {
+	q := &fakeQueue{errs: []error{raft.NewNotLeaderError("10.0.0.1", "2")}}
+	forwarder := &fakeForwarder{}
+	m := NewRaftMediator(q, mediatorLogger(), forwarder)
+
+	err := m.ApplyLease([]byte("cmd"))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(forwarder.hops, gc.DeepEquals, []int{1})
+	c.Check(m.Metrics(), gc.Equals, RaftMediatorMetrics{Forwarded: 1})
+}
+
+func (s *RaftMediatorSuite) TestApplyLeaseNoForwarderReturnsNotLeaderError(c *gc.C) {
+	q := &fakeQueue{errs: []error{raft.NewNotLeaderError("10.0.0.1", "2")}}
+	m := NewRaftMediator(q, mediatorLogger(), nil)
+
+	err := m.ApplyLease([]byte("cmd"))
+	c.Assert(err, gc.ErrorMatches, ".*10.0.0.1.*")
+}
+
+func (s *RaftMediatorSuite) TestApplyLeaseStopsForwardingAfterMaxHops(c *gc.C) {
+	q := &fakeQueue{errs: []error{raft.NewNotLeaderError("10.0.0.1", "2")}}
+	forwarder := &fakeForwarder{
+		errs: []error{raft.NewNotLeaderError("10.0.0.2", "3")},
+	}
+	m := NewRaftMediator(q, mediatorLogger(), forwarder)
+
+	err := m.applyLease([]byte("cmd"), maxForwardingHops)
+	c.Assert(err, gc.ErrorMatches, ".*10.0.0.1.*")
+	c.Check(forwarder.hops, gc.HasLen, 0)
+}
+
+func (s *RaftMediatorSuite) TestApplyLeaseForwardHopExhaustion(c *gc.C) {
+	q := &fakeQueue{errs: []error{raft.NewNotLeaderError("10.0.0.1", "2")}}
+	forwarder := &fakeForwarder{
+		errs: []error{raft.NewNotLeaderError("10.0.0.2", "3")},
+	}
+	m := NewRaftMediator(q, mediatorLogger(), forwarder)
+
+	err := m.applyLease([]byte("cmd"), maxForwardingHops-1)
+	c.Assert(err, gc.ErrorMatches, ".*10.0.0.2.*")
+	c.Check(forwarder.hops, gc.DeepEquals, []int{maxForwardingHops})
+}
+
+func (s *RaftMediatorSuite) TestApplyLeaseDeadlineExceededPassthrough(c *gc.C) {
+	q := &fakeQueue{errs: []error{queue.ErrDeadlineExceeded}}
+	m := NewRaftMediator(q, mediatorLogger(), nil)
+
+	err := m.ApplyLease([]byte("cmd"))
+	c.Assert(errors.Cause(err), gc.ErrorMatches, ".*deadline exceeded.*")
+}
+
+func (s *RaftMediatorSuite) TestApplyLeaseForwardDeadlineExceededPassthrough(c *gc.C) {
+	q := &fakeQueue{errs: []error{raft.NewNotLeaderError("10.0.0.1", "2")}}
+	forwarder := &fakeForwarder{errs: []error{queue.ErrDeadlineExceeded}}
+	m := NewRaftMediator(q, mediatorLogger(), forwarder)
+
+	err := m.ApplyLease([]byte("cmd"))
+	c.Assert(errors.Cause(err), gc.ErrorMatches, ".*deadline exceeded.*")
+}
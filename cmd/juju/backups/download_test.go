@@ -0,0 +1,37 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package backups
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPartFilename(t *testing.T) {
+	if got, want := partFilename("backup.tar.gz"), "backup.tar.gz.part"; got != want {
+		t.Errorf("partFilename: got %q, want %q", got, want)
+	}
+}
+
+func TestIdFilename(t *testing.T) {
+	part := partFilename("backup.tar.gz")
+	if got, want := idFilename(part), "backup.tar.gz.part.id"; got != want {
+		t.Errorf("idFilename: got %q, want %q", got, want)
+	}
+}
+
+func TestProgressWriterReportsOnCompletion(t *testing.T) {
+	var out bytes.Buffer
+	w := newProgressWriter(&out, 10, 0)
+	n, err := w.Write(make([]byte, 10))
+	if err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	if n != 10 {
+		t.Fatalf("Write returned %d, want 10", n)
+	}
+	if out.Len() == 0 {
+		t.Fatal("expected progress output once the archive is complete")
+	}
+}
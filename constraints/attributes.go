@@ -0,0 +1,58 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package constraints
+
+import (
+	"sync"
+)
+
+// AttributeKind classifies the values accepted by an attribute registered
+// with RegisterAttribute. It is recorded with the registration only: no
+// Validator method consults it, so RegisterRange, for example, accepts an
+// attribute of either kind.
+type AttributeKind int
+
+const (
+	// KindString indicates that the attribute's parsed value is a string.
+	KindString AttributeKind = iota
+
+	// KindNumeric indicates that the attribute's parsed value is numeric,
+	// ie one of the types toNumeric accepts.
+	KindNumeric
+)
+
+// attributeDef describes an attribute registered with RegisterAttribute.
+type attributeDef struct {
+	kind   AttributeKind
+	parse  func(string) (interface{}, error)
+	format func(interface{}) string
+}
+
+var (
+	attributesMu sync.RWMutex
+	attributes   = make(map[string]attributeDef)
+)
+
+// RegisterAttribute adds name to the set of constraint attributes that
+// Parse and String recognise, alongside the fixed fields of Value. This
+// gives a provider-specific constraint, such as "gpu", the same
+// first-class parsing, formatting and Validator support as "arch" or
+// "mem": parse converts the raw string following "name=" into the
+// attribute's value, and format renders that value back for String.
+// Providers typically call RegisterAttribute from an init function.
+//
+// Registering the same name twice replaces the earlier registration.
+func RegisterAttribute(name string, kind AttributeKind, parse func(string) (interface{}, error), format func(interface{}) string) {
+	attributesMu.Lock()
+	defer attributesMu.Unlock()
+	attributes[name] = attributeDef{kind: kind, parse: parse, format: format}
+}
+
+// lookupAttribute returns the definition registered for name, if any.
+func lookupAttribute(name string) (attributeDef, bool) {
+	attributesMu.RLock()
+	defer attributesMu.RUnlock()
+	def, ok := attributes[name]
+	return def, ok
+}
@@ -0,0 +1,504 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// Package constraints defines the constraints used to select machines on
+// which to deploy applications and units.
+package constraints
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// Value describes a user's requirements of the hardware on which units
+// of an application will run. Constraints are used to choose an existing
+// machine onto which a unit will be deployed, or to provision a new
+// machine if no existing one satisfies the requirements.
+type Value struct {
+	// Arch, if not nil or empty, indicates that a machine must run the
+	// named architecture.
+	Arch *string `json:"arch,omitempty" yaml:"arch,omitempty"`
+
+	// Container, if not nil, indicates that a machine must be the specified
+	// container type.
+	Container *string `json:"container,omitempty" yaml:"container,omitempty"`
+
+	// CpuCores, if not nil, indicates that a machine must have at least
+	// that number of effective cores available.
+	CpuCores *uint64 `json:"cpu-cores,omitempty" yaml:"cpu-cores,omitempty"`
+
+	// CpuPower, if not nil, indicates that a machine must have at least
+	// that amount of CPU power available, where 100 units is roughly
+	// equivalent to 1 GHz of a single core.
+	CpuPower *uint64 `json:"cpu-power,omitempty" yaml:"cpu-power,omitempty"`
+
+	// Mem, if not nil, indicates that a machine must have at least that
+	// many megabytes of RAM.
+	Mem *uint64 `json:"mem,omitempty" yaml:"mem,omitempty"`
+
+	// RootDisk, if not nil, indicates that a machine must have at least
+	// that many megabytes of disk space available in the root disk.
+	RootDisk *uint64 `json:"root-disk,omitempty" yaml:"root-disk,omitempty"`
+
+	// Tags, if not nil, indicates tags that the machine must have applied to it.
+	Tags *[]string `json:"tags,omitempty" yaml:"tags,omitempty"`
+
+	// InstanceType, if not nil, indicates that the specified cloud instance type
+	// be used. Only valid for clouds which support instance types.
+	InstanceType *string `json:"instance-type,omitempty" yaml:"instance-type,omitempty"`
+
+	// VirtType, if not nil, indicates that a machine must run the named
+	// virtualisation type. Only valid for clouds with multiple virtualisation
+	// types available.
+	VirtType *string `json:"virt-type,omitempty" yaml:"virt-type,omitempty"`
+
+	// extra holds the values of attributes registered with
+	// RegisterAttribute, keyed by name. It exists so that a provider can
+	// give its own constraints (eg "gpu") the same first-class parsing,
+	// formatting and validation as the fields above, without Value itself
+	// needing to change. Unlike the fields above it is not marshalled:
+	// an attribute's registration, not Value, owns how it round-trips.
+	extra map[string]interface{}
+}
+
+// fieldNames lists the fixed attributes of Value in the order String
+// renders them. setAttrNames uses it to find which of them are set;
+// attributes registered with RegisterAttribute are not in it.
+var fieldNames = []string{
+	"arch", "container", "cpu-cores", "cpu-power", "mem",
+	"root-disk", "tags", "instance-type", "virt-type",
+}
+
+// String expresses a constraints.Value in the language in which it was
+// specified, from the fields that are set, in a consistent order.
+func (v Value) String() string {
+	var strs []string
+	if v.Arch != nil {
+		strs = append(strs, "arch="+*v.Arch)
+	}
+	if v.Container != nil {
+		strs = append(strs, "container="+string(*v.Container))
+	}
+	if v.CpuCores != nil {
+		strs = append(strs, "cpu-cores="+uintStr(*v.CpuCores))
+	}
+	if v.CpuPower != nil {
+		strs = append(strs, "cpu-power="+uintStr(*v.CpuPower))
+	}
+	if v.Mem != nil {
+		s := uintStr(*v.Mem) + "M"
+		strs = append(strs, "mem="+s)
+	}
+	if v.RootDisk != nil {
+		s := uintStr(*v.RootDisk) + "M"
+		strs = append(strs, "root-disk="+s)
+	}
+	if v.Tags != nil {
+		s := strings.Join(*v.Tags, ",")
+		strs = append(strs, "tags="+s)
+	}
+	if v.InstanceType != nil {
+		strs = append(strs, "instance-type="+*v.InstanceType)
+	}
+	if v.VirtType != nil {
+		strs = append(strs, "virt-type="+*v.VirtType)
+	}
+	for _, name := range v.extraNames() {
+		def, ok := lookupAttribute(name)
+		if !ok {
+			// The attribute was unregistered after being set; there is
+			// no longer a way to format it, so leave it out rather than
+			// produce a string Parse can't read back.
+			continue
+		}
+		strs = append(strs, name+"="+def.format(v.extra[name]))
+	}
+	return strings.Join(strs, " ")
+}
+
+// extraNames returns the names of the attributes set via RegisterAttribute,
+// in a consistent order.
+func (v Value) extraNames() []string {
+	if len(v.extra) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(v.extra))
+	for name := range v.extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// uintStr converts a uint64 into a string.
+func uintStr(i uint64) string {
+	if i == 0 {
+		return ""
+	}
+	return strconv.FormatUint(i, 10)
+}
+
+// Parse constructs a constraints.Value from the supplied arguments,
+// each of which must contain only spaces and name=value pairs. If any
+// name is specified more than once, an error is returned.
+func Parse(args ...string) (Value, error) {
+	cons := Value{}
+	for _, arg := range args {
+		raw := strings.Split(strings.TrimSpace(arg), " ")
+		for _, str := range raw {
+			str = strings.TrimSpace(str)
+			if str == "" {
+				continue
+			}
+			if err := cons.setRaw(str); err != nil {
+				return Value{}, errors.Trace(err)
+			}
+		}
+	}
+	return cons, nil
+}
+
+// MustParse constructs a constraints.Value from the supplied arguments,
+// as Parse, but panics on failure.
+func MustParse(args ...string) Value {
+	v, err := Parse(args...)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// setRaw interprets a name=value string and sets the supplied value.
+func (v *Value) setRaw(raw string) error {
+	eq := strings.Index(raw, "=")
+	if eq <= 0 {
+		return errors.Errorf("malformed constraint %q", raw)
+	}
+	name, str := raw[:eq], raw[eq+1:]
+	var err error
+	switch name {
+	case "arch":
+		err = v.setArch(str)
+	case "container":
+		err = v.setContainer(str)
+	case "cpu-cores":
+		err = v.setCpuCores(str)
+	case "cpu-power":
+		err = v.setCpuPower(str)
+	case "mem":
+		err = v.setMem(str)
+	case "root-disk":
+		err = v.setRootDisk(str)
+	case "tags":
+		err = v.setTags(str)
+	case "instance-type":
+		err = v.setInstanceType(str)
+	case "virt-type":
+		err = v.setVirtType(str)
+	default:
+		def, ok := lookupAttribute(name)
+		if !ok {
+			return errors.Errorf("unknown constraint %q", name)
+		}
+		val, parseErr := def.parse(str)
+		if parseErr != nil {
+			return errors.Trace(parseErr)
+		}
+		err = v.setExtra(name, val)
+	}
+	return errors.Trace(err)
+}
+
+// setExtra records the value of an attribute registered with
+// RegisterAttribute.
+func (v *Value) setExtra(name string, val interface{}) error {
+	if _, ok := v.extra[name]; ok {
+		return errors.Errorf("already set")
+	}
+	if v.extra == nil {
+		v.extra = make(map[string]interface{})
+	}
+	v.extra[name] = val
+	return nil
+}
+
+func (v *Value) setArch(str string) error {
+	if v.Arch != nil {
+		return errors.Errorf("already set")
+	}
+	v.Arch = &str
+	return nil
+}
+
+func (v *Value) setContainer(str string) error {
+	if v.Container != nil {
+		return errors.Errorf("already set")
+	}
+	v.Container = &str
+	return nil
+}
+
+func (v *Value) setInstanceType(str string) error {
+	if v.InstanceType != nil {
+		return errors.Errorf("already set")
+	}
+	v.InstanceType = &str
+	return nil
+}
+
+func (v *Value) setVirtType(str string) error {
+	if v.VirtType != nil {
+		return errors.Errorf("already set")
+	}
+	v.VirtType = &str
+	return nil
+}
+
+func (v *Value) setCpuCores(str string) (err error) {
+	if v.CpuCores != nil {
+		return errors.Errorf("already set")
+	}
+	v.CpuCores, err = parseUint64(str)
+	return
+}
+
+func (v *Value) setCpuPower(str string) (err error) {
+	if v.CpuPower != nil {
+		return errors.Errorf("already set")
+	}
+	v.CpuPower, err = parseUint64(str)
+	return
+}
+
+func (v *Value) setMem(str string) (err error) {
+	if v.Mem != nil {
+		return errors.Errorf("already set")
+	}
+	v.Mem, err = parseSize(str)
+	return
+}
+
+func (v *Value) setRootDisk(str string) (err error) {
+	if v.RootDisk != nil {
+		return errors.Errorf("already set")
+	}
+	v.RootDisk, err = parseSize(str)
+	return
+}
+
+func (v *Value) setTags(str string) error {
+	if v.Tags != nil {
+		return errors.Errorf("already set")
+	}
+	if str == "" {
+		v.Tags = &[]string{}
+		return nil
+	}
+	tags := strings.Split(str, ",")
+	v.Tags = &tags
+	return nil
+}
+
+func parseUint64(str string) (*uint64, error) {
+	var value uint64
+	if str != "" {
+		var err error
+		value, err = strconv.ParseUint(str, 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("must be a non-negative integer")
+		}
+	}
+	return &value, nil
+}
+
+// parseSize parses a string such as "512M" or "4G" into a number of
+// megabytes.
+func parseSize(str string) (*uint64, error) {
+	if str == "" {
+		var value uint64
+		return &value, nil
+	}
+	mult := uint64(1)
+	if len(str) > 1 {
+		switch str[len(str)-1] {
+		case 'M':
+			str = str[:len(str)-1]
+		case 'G':
+			mult = 1024
+			str = str[:len(str)-1]
+		case 'T':
+			mult = 1024 * 1024
+			str = str[:len(str)-1]
+		case 'P':
+			mult = 1024 * 1024 * 1024
+			str = str[:len(str)-1]
+		}
+	}
+	value, err := strconv.ParseUint(str, 10, 64)
+	if err != nil {
+		return nil, errors.Errorf("must be a non-negative integer with optional M/G/T/P suffix")
+	}
+	value *= mult
+	return &value, nil
+}
+
+// attrValue returns the value of the named attribute, and whether it is
+// set, for use by the validation package.
+func (v Value) attrValue(name string) (interface{}, bool) {
+	switch name {
+	case "arch":
+		if v.Arch == nil {
+			return nil, false
+		}
+		return *v.Arch, true
+	case "container":
+		if v.Container == nil {
+			return nil, false
+		}
+		return *v.Container, true
+	case "cpu-cores":
+		if v.CpuCores == nil {
+			return nil, false
+		}
+		return *v.CpuCores, true
+	case "cpu-power":
+		if v.CpuPower == nil {
+			return nil, false
+		}
+		return *v.CpuPower, true
+	case "mem":
+		if v.Mem == nil {
+			return nil, false
+		}
+		return *v.Mem, true
+	case "root-disk":
+		if v.RootDisk == nil {
+			return nil, false
+		}
+		return *v.RootDisk, true
+	case "tags":
+		if v.Tags == nil {
+			return nil, false
+		}
+		return *v.Tags, true
+	case "instance-type":
+		if v.InstanceType == nil {
+			return nil, false
+		}
+		return *v.InstanceType, true
+	case "virt-type":
+		if v.VirtType == nil {
+			return nil, false
+		}
+		return *v.VirtType, true
+	}
+	if val, ok := v.extra[name]; ok {
+		return val, true
+	}
+	return nil, false
+}
+
+// hasAny returns true if any of the named attributes are set.
+func (v *Value) hasAny(names ...string) []string {
+	var result []string
+	for _, name := range names {
+		if _, ok := v.attrValue(name); ok {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// setAttrNames returns the names of every attribute that is set, whether
+// one of the fixed fields above or one registered with RegisterAttribute.
+func (v Value) setAttrNames() []string {
+	return append(v.hasAny(fieldNames...), v.extraNames()...)
+}
+
+// without returns a copy of v with the named attributes cleared.
+func (v Value) without(names ...string) Value {
+	if v.extra != nil {
+		cloned := make(map[string]interface{}, len(v.extra))
+		for k, val := range v.extra {
+			cloned[k] = val
+		}
+		v.extra = cloned
+	}
+	for _, name := range names {
+		switch name {
+		case "arch":
+			v.Arch = nil
+		case "container":
+			v.Container = nil
+		case "cpu-cores":
+			v.CpuCores = nil
+		case "cpu-power":
+			v.CpuPower = nil
+		case "mem":
+			v.Mem = nil
+		case "root-disk":
+			v.RootDisk = nil
+		case "tags":
+			v.Tags = nil
+		case "instance-type":
+			v.InstanceType = nil
+		case "virt-type":
+			v.VirtType = nil
+		default:
+			delete(v.extra, name)
+		}
+	}
+	return v
+}
+
+// setAttr sets the named attribute to value, which must be of the type
+// produced by attrValue for that attribute.
+func (v *Value) setAttr(name string, value interface{}) {
+	switch name {
+	case "arch":
+		s := value.(string)
+		v.Arch = &s
+	case "container":
+		s := value.(string)
+		v.Container = &s
+	case "cpu-cores":
+		n := value.(uint64)
+		v.CpuCores = &n
+	case "cpu-power":
+		n := value.(uint64)
+		v.CpuPower = &n
+	case "mem":
+		n := value.(uint64)
+		v.Mem = &n
+	case "root-disk":
+		n := value.(uint64)
+		v.RootDisk = &n
+	case "tags":
+		s := value.([]string)
+		v.Tags = &s
+	case "instance-type":
+		s := value.(string)
+		v.InstanceType = &s
+	case "virt-type":
+		s := value.(string)
+		v.VirtType = &s
+	default:
+		if v.extra == nil {
+			v.extra = make(map[string]interface{})
+		}
+		v.extra[name] = value
+	}
+}
+
+// GoString allows Value to satisfy fmt.GoStringer, which is useful when
+// values appear in test failure output.
+func (v Value) GoString() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "constraints.MustParse(%q)", v.String())
+	return buf.String()
+}
@@ -0,0 +1,461 @@
+// Copyright 2013-2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package constraints
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/juju/errors"
+)
+
+// Validator constrains what constraints are valid, taking into account
+// the attributes of the underlying cloud.
+type Validator struct {
+	unsupported    map[string]bool
+	vocab          map[string][]interface{}
+	ranges         map[string]valueRange
+	predicates     map[string]func(interface{}) error
+	conflicts      map[string]map[string]bool
+	conflictPolicy map[string]map[string]ConflictPolicy
+}
+
+// ConflictPolicy determines how a conflict between two attributes
+// registered with RegisterConflictResolution is resolved, in place of
+// the "ambiguous constraints" error that conflicting attributes
+// otherwise produce.
+type ConflictPolicy int
+
+const (
+	// PolicyError rejects the conflict with an "ambiguous constraints"
+	// error. This is the default policy for any conflict that has not
+	// been given an explicit resolution.
+	PolicyError ConflictPolicy = iota
+
+	// PolicyPreferFirst, in Merge(consFallback, cons), keeps the
+	// attribute from consFallback and drops the conflicting attribute
+	// from cons.
+	PolicyPreferFirst
+
+	// PolicyPreferLast, in Merge(consFallback, cons), keeps the
+	// attribute from cons and drops the conflicting attribute from
+	// consFallback. This is the same outcome Merge already produces,
+	// by default, for a conflict attribute that only consFallback sets.
+	PolicyPreferLast
+
+	// PolicyDrop discards every attribute in the conflicting pair.
+	PolicyDrop
+)
+
+// valueRange describes the inclusive [min, max] bounds registered for a
+// numeric attribute via RegisterRange.
+type valueRange struct {
+	min, max interface{}
+}
+
+// NewValidator returns a new Validator instance.
+func NewValidator() *Validator {
+	return &Validator{
+		unsupported:    make(map[string]bool),
+		vocab:          make(map[string][]interface{}),
+		ranges:         make(map[string]valueRange),
+		predicates:     make(map[string]func(interface{}) error),
+		conflicts:      make(map[string]map[string]bool),
+		conflictPolicy: make(map[string]map[string]ConflictPolicy),
+	}
+}
+
+// RegisterConflicts is used to define incompatible attribute values.
+// Eg "container" cannot be set if "instance-type" is also set.
+// And "instance-type" cannot be set if either "mem" or "arch" are also set.
+// instanceTypeAttr, containerAttr, arch ... )
+func (v *Validator) RegisterConflicts(reds, blues []string) {
+	conflictRange := func(left, right []string) {
+		for _, leftAttr := range left {
+			for _, rightAttr := range right {
+				v.addConflict(leftAttr, rightAttr)
+			}
+		}
+	}
+	conflictRange(reds, blues)
+	conflictRange(blues, reds)
+}
+
+func (v *Validator) addConflict(attributeName, conflictAttributeName string) {
+	if v.conflicts[attributeName] == nil {
+		v.conflicts[attributeName] = make(map[string]bool)
+	}
+	v.conflicts[attributeName][conflictAttributeName] = true
+}
+
+// RegisterConflictResolution declares every attribute in group as
+// conflicting with every other attribute in group (as RegisterConflicts
+// would), but resolves the conflict according to policy instead of
+// Validate and Merge's default of rejecting it outright.
+func (v *Validator) RegisterConflictResolution(group []string, policy ConflictPolicy) {
+	for _, attr := range group {
+		for _, conflict := range group {
+			if attr == conflict {
+				continue
+			}
+			v.addConflict(attr, conflict)
+			v.setConflictPolicy(attr, conflict, policy)
+		}
+	}
+}
+
+func (v *Validator) setConflictPolicy(attributeName, conflictAttributeName string, policy ConflictPolicy) {
+	if v.conflictPolicy[attributeName] == nil {
+		v.conflictPolicy[attributeName] = make(map[string]ConflictPolicy)
+	}
+	v.conflictPolicy[attributeName][conflictAttributeName] = policy
+}
+
+// policyFor returns the resolution policy registered for the conflict
+// between attributeName and conflictAttributeName, and whether one has
+// been registered at all via RegisterConflictResolution. A conflict
+// registered only through RegisterConflicts has no policy of its own.
+func (v *Validator) policyFor(attributeName, conflictAttributeName string) (policy ConflictPolicy, registered bool) {
+	policy, registered = v.conflictPolicy[attributeName][conflictAttributeName]
+	return policy, registered
+}
+
+// RegisterUnsupported records attributes which are not supported by a
+// provider.
+func (v *Validator) RegisterUnsupported(attributeNames []string) {
+	for _, a := range attributeNames {
+		v.unsupported[a] = true
+	}
+}
+
+// RegisterVocabulary records the allowed values for the specified
+// attribute. validValues may be any slice type (eg []string, []int,
+// []interface{}).
+func (v *Validator) RegisterVocabulary(attributeName string, validValues interface{}) {
+	v.vocab[attributeName] = append(v.vocab[attributeName], toInterfaceSlice(validValues)...)
+}
+
+// RegisterRange records that the specified attribute's value must lie
+// within [min, max] inclusive. min and max may be anything accepted by
+// parseSize (eg "512M", "4G") or a plain numeric value; whichever
+// representation is passed in is what appears in the "valid range is:"
+// error message, so callers should pass whatever is most natural for the
+// attribute (eg the same units used by the constraint itself).
+func (v *Validator) RegisterRange(attributeName string, min, max interface{}) {
+	v.ranges[attributeName] = valueRange{min: min, max: max}
+}
+
+// RegisterPredicate records an arbitrary validation function for the
+// specified attribute. fn is called with the parsed attribute value (eg
+// the uint64 for "cpu-cores") and should return a descriptive error if
+// the value is not acceptable.
+func (v *Validator) RegisterPredicate(attributeName string, fn func(interface{}) error) {
+	v.predicates[attributeName] = fn
+}
+
+// UpdateVocabulary adds more valid values to an existing or new
+// vocabulary.
+func (v *Validator) UpdateVocabulary(attributeName string, additionalValues interface{}) {
+	v.vocab[attributeName] = append(v.vocab[attributeName], toInterfaceSlice(additionalValues)...)
+}
+
+// IntersectVocabulary updates the vocabulary for an attribute so that it
+// contains only the intersection of the existing vocabulary and
+// newValues. If the attribute has no existing vocabulary, the result is
+// an empty vocabulary (ie nothing will validate) unless newValues is
+// itself empty, in which case the attribute is left unconstrained.
+func (v *Validator) IntersectVocabulary(attributeName string, newValues interface{}) {
+	newSlice := toInterfaceSlice(newValues)
+	currentValues, ok := v.vocab[attributeName]
+	if !ok {
+		if len(newSlice) == 0 {
+			return
+		}
+		v.vocab[attributeName] = []interface{}{}
+		return
+	}
+	var kept []interface{}
+	for _, cv := range currentValues {
+		for _, nv := range newSlice {
+			if fmt.Sprint(cv) == fmt.Sprint(nv) {
+				kept = append(kept, cv)
+				break
+			}
+		}
+	}
+	v.vocab[attributeName] = kept
+}
+
+// IntersectRange narrows an existing registered range for attributeName
+// so that it is the intersection of the current range and [min, max]:
+// the lower bound becomes the greater of the two minimums, and the upper
+// bound becomes the lesser of the two maximums. If the attribute has no
+// range registered yet, [min, max] is registered directly. If the
+// resulting range is empty (min > max), the attribute is left
+// registered with an impossible range, so that every value of that
+// attribute fails validation, mirroring the behaviour of
+// IntersectVocabulary on an empty set.
+func (v *Validator) IntersectRange(attributeName string, min, max interface{}) {
+	current, ok := v.ranges[attributeName]
+	if !ok {
+		v.ranges[attributeName] = valueRange{min: min, max: max}
+		return
+	}
+	newMin := current.min
+	if greaterNumeric(min, newMin) {
+		newMin = min
+	}
+	newMax := current.max
+	if lesserNumeric(max, newMax) {
+		newMax = max
+	}
+	v.ranges[attributeName] = valueRange{min: newMin, max: newMax}
+}
+
+// Validate returns an error if the given constraints are not valid, and
+// also any unsupported attributes.
+func (v *Validator) Validate(cons Value) ([]string, error) {
+	attrs := cons.setAttrNames()
+	var unsupported []string
+	for _, attr := range attrs {
+		if v.unsupported[attr] {
+			unsupported = append(unsupported, attr)
+		}
+	}
+	if err := v.checkConflicts(attrs); err != nil {
+		return unsupported, err
+	}
+	if err := v.checkVocabs(attrs, cons); err != nil {
+		return unsupported, err
+	}
+	if err := v.checkRanges(attrs, cons); err != nil {
+		return unsupported, err
+	}
+	if err := v.checkPredicates(attrs, cons); err != nil {
+		return unsupported, err
+	}
+	return unsupported, nil
+}
+
+// checkConflicts looks for an attribute in attrs whose registered
+// conflicts also appear in attrs.
+func (v *Validator) checkConflicts(attrs []string) error {
+	sorted := append([]string(nil), attrs...)
+	sort.Strings(sorted)
+	attrSet := make(map[string]bool, len(attrs))
+	for _, attr := range attrs {
+		attrSet[attr] = true
+	}
+	for _, attr0 := range sorted {
+		for attr1 := range v.conflicts[attr0] {
+			if !attrSet[attr1] {
+				continue
+			}
+			if policy, registered := v.policyFor(attr0, attr1); registered && policy != PolicyError {
+				// A non-error resolution policy has been registered for
+				// this pair, so it is no longer unconditionally
+				// ambiguous; Merge is responsible for applying it.
+				continue
+			}
+			return errors.Errorf("ambiguous constraints: %q overlaps with %q", attr0, attr1)
+		}
+	}
+	return nil
+}
+
+func (v *Validator) checkVocabs(attrs []string, cons Value) error {
+	for _, attr := range attrs {
+		vocab, ok := v.vocab[attr]
+		if !ok {
+			continue
+		}
+		val, _ := cons.attrValue(attr)
+		if tags, isTags := val.([]string); isTags {
+			for _, tag := range tags {
+				if !containsValue(vocab, tag) {
+					return errors.Errorf("invalid constraint value: %s=%v\nvalid values are: %v", attr, tag, vocab)
+				}
+			}
+			continue
+		}
+		if !containsValue(vocab, val) {
+			return errors.Errorf("invalid constraint value: %s=%v\nvalid values are: %v", attr, val, vocab)
+		}
+	}
+	return nil
+}
+
+func (v *Validator) checkRanges(attrs []string, cons Value) error {
+	for _, attr := range attrs {
+		r, ok := v.ranges[attr]
+		if !ok {
+			continue
+		}
+		val, _ := cons.attrValue(attr)
+		if lesserNumeric(val, r.min) || greaterNumeric(val, r.max) {
+			return errors.Errorf("invalid constraint value: %s=%v\nvalid range is: [%v, %v]", attr, val, r.min, r.max)
+		}
+	}
+	return nil
+}
+
+func (v *Validator) checkPredicates(attrs []string, cons Value) error {
+	for _, attr := range attrs {
+		fn, ok := v.predicates[attr]
+		if !ok {
+			continue
+		}
+		val, _ := cons.attrValue(attr)
+		if err := fn(val); err != nil {
+			return errors.Errorf("invalid constraint value: %s=%v\n%v", attr, val, err)
+		}
+	}
+	return nil
+}
+
+// Merge merges cons with consFallback, and returns the result. Any
+// attribute set in cons is left alone; any attribute not set in cons is
+// taken from consFallback unless doing so would conflict with an
+// attribute already set (in either value).
+//
+// By default a conflicting fallback attribute is silently dropped
+// (equivalent to PolicyPreferLast) and, if the conflict instead arises
+// between two attributes both coming from the same side, merging fails
+// with an "ambiguous constraints" error. RegisterConflictResolution can
+// override this: PolicyPreferFirst keeps the fallback attribute instead
+// and drops the override's, PolicyDrop discards both, and PolicyError
+// turns even a fallback/override split into a hard error.
+func (v *Validator) Merge(consFallback, cons Value) (Value, error) {
+	setAttrs := cons.setAttrNames()
+	setAttrSet := make(map[string]bool, len(setAttrs))
+	for _, attr := range setAttrs {
+		setAttrSet[attr] = true
+	}
+	var toMerge []string
+	var dropFromCons []string
+	for _, attr := range consFallback.setAttrNames() {
+		if setAttrSet[attr] {
+			continue
+		}
+		keep := true
+		for conflict := range v.conflicts[attr] {
+			if !setAttrSet[conflict] {
+				continue
+			}
+			policy, registered := v.policyFor(attr, conflict)
+			if !registered {
+				// No resolution policy: fall back to Merge's
+				// historical behaviour of silently dropping the
+				// fallback attribute.
+				keep = false
+				continue
+			}
+			switch policy {
+			case PolicyPreferFirst:
+				dropFromCons = append(dropFromCons, conflict)
+			case PolicyDrop:
+				keep = false
+				dropFromCons = append(dropFromCons, conflict)
+			case PolicyPreferLast:
+				keep = false
+			case PolicyError:
+				// Leave both attributes in place; the final
+				// checkConflicts call below will reject them.
+			}
+		}
+		if keep {
+			toMerge = append(toMerge, attr)
+		}
+	}
+
+	finalAttrs := make([]string, 0, len(setAttrs)+len(toMerge))
+	dropped := make(map[string]bool, len(dropFromCons))
+	for _, attr := range dropFromCons {
+		dropped[attr] = true
+	}
+	for _, attr := range setAttrs {
+		if !dropped[attr] {
+			finalAttrs = append(finalAttrs, attr)
+		}
+	}
+	finalAttrs = append(finalAttrs, toMerge...)
+	if err := v.checkConflicts(finalAttrs); err != nil {
+		return Value{}, errors.Trace(err)
+	}
+
+	result := cons.without(dropFromCons...)
+	for _, attr := range toMerge {
+		val, _ := consFallback.attrValue(attr)
+		result.setAttr(attr, val)
+	}
+	return result, nil
+}
+
+// toInterfaceSlice converts any slice value into a []interface{}
+// containing the same elements, so that RegisterVocabulary and friends
+// can accept []string, []int, []interface{}, etc.
+func toInterfaceSlice(values interface{}) []interface{} {
+	rv := reflect.ValueOf(values)
+	if rv.Kind() != reflect.Slice {
+		return nil
+	}
+	result := make([]interface{}, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		result[i] = rv.Index(i).Interface()
+	}
+	return result
+}
+
+// containsValue reports whether vocab contains a value that renders the
+// same as value; values may be of different concrete types (eg the
+// vocabulary may be []int while the constraint was parsed as uint64), so
+// comparison is done on the string representation.
+func containsValue(vocab []interface{}, value interface{}) bool {
+	for _, v := range vocab {
+		if fmt.Sprint(v) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// toNumeric converts val to a float64 for range comparisons. It accepts
+// the integer types constraints are parsed into, plain numeric strings,
+// and size strings such as "512M" or "4G".
+func toNumeric(val interface{}) (float64, bool) {
+	switch t := val.(type) {
+	case uint64:
+		return float64(t), true
+	case uint:
+		return float64(t), true
+	case int:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	case float64:
+		return t, true
+	case string:
+		if size, err := parseSize(t); err == nil {
+			return float64(*size), true
+		}
+	}
+	return 0, false
+}
+
+// lesserNumeric reports whether a < b, treating either side as numeric
+// if possible; non-numeric values never compare as lesser.
+func lesserNumeric(a, b interface{}) bool {
+	af, aok := toNumeric(a)
+	bf, bok := toNumeric(b)
+	return aok && bok && af < bf
+}
+
+// greaterNumeric reports whether a > b, treating either side as numeric
+// if possible; non-numeric values never compare as greater.
+func greaterNumeric(a, b interface{}) bool {
+	af, aok := toNumeric(a)
+	bf, bok := toNumeric(b)
+	return aok && bok && af > bf
+}
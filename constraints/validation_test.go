@@ -6,6 +6,7 @@ package constraints_test
 import (
 	"regexp"
 
+	"github.com/juju/errors"
 	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
 
@@ -359,6 +360,139 @@ func (s *validationSuite) TestMergeError(c *gc.C) {
 	c.Assert(err, gc.ErrorMatches, `ambiguous constraints: "instance-type" overlaps with "mem"`)
 }
 
+func (s *validationSuite) TestMergeConflictResolutionPreferLast(c *gc.C) {
+	validator := constraints.NewValidator()
+	validator.RegisterConflictResolution([]string{"instance-type", "mem"}, constraints.PolicyPreferLast)
+
+	consFallback := constraints.MustParse("instance-type=foo")
+	cons := constraints.MustParse("mem=4G")
+	merged, err := validator.Merge(consFallback, cons)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(merged, gc.DeepEquals, cons)
+}
+
+func (s *validationSuite) TestMergeConflictResolutionPreferFirst(c *gc.C) {
+	validator := constraints.NewValidator()
+	validator.RegisterConflictResolution([]string{"instance-type", "mem"}, constraints.PolicyPreferFirst)
+
+	consFallback := constraints.MustParse("instance-type=foo")
+	cons := constraints.MustParse("mem=4G cpu-cores=2")
+	merged, err := validator.Merge(consFallback, cons)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(merged, gc.DeepEquals, constraints.MustParse("instance-type=foo cpu-cores=2"))
+}
+
+func (s *validationSuite) TestMergeConflictResolutionDrop(c *gc.C) {
+	validator := constraints.NewValidator()
+	validator.RegisterConflictResolution([]string{"instance-type", "mem"}, constraints.PolicyDrop)
+
+	consFallback := constraints.MustParse("instance-type=foo")
+	cons := constraints.MustParse("mem=4G cpu-cores=2")
+	merged, err := validator.Merge(consFallback, cons)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(merged, gc.DeepEquals, constraints.MustParse("cpu-cores=2"))
+}
+
+func (s *validationSuite) TestMergeConflictResolutionError(c *gc.C) {
+	validator := constraints.NewValidator()
+	validator.RegisterConflictResolution([]string{"instance-type", "mem"}, constraints.PolicyError)
+
+	consFallback := constraints.MustParse("instance-type=foo")
+	cons := constraints.MustParse("mem=4G")
+	_, err := validator.Merge(consFallback, cons)
+	c.Assert(err, gc.ErrorMatches, `ambiguous constraints: "instance-type" overlaps with "mem"`)
+}
+
+func (s *validationSuite) TestValidateConflictResolution(c *gc.C) {
+	validator := constraints.NewValidator()
+	validator.RegisterConflictResolution([]string{"instance-type", "mem"}, constraints.PolicyPreferFirst)
+
+	cons := constraints.MustParse("instance-type=foo mem=4G")
+	_, err := validator.Validate(cons)
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+var rangeTests = []struct {
+	cons string
+	attr string
+	min  interface{}
+	max  interface{}
+	err  string
+}{
+	{
+		cons: "mem=4G",
+		attr: "mem",
+		min:  "512M",
+		max:  "512G",
+	}, {
+		cons: "mem=256M",
+		attr: "mem",
+		min:  "512M",
+		max:  "512G",
+		err:  `invalid constraint value: mem=256\nvalid range is: \[512M, 512G\]`,
+	}, {
+		cons: "root-disk=1T",
+		attr: "root-disk",
+		min:  "1G",
+		max:  "512G",
+		err:  `invalid constraint value: root-disk=1048576\nvalid range is: \[1G, 512G\]`,
+	}, {
+		cons: "cpu-power=500",
+		attr: "cpu-power",
+		min:  100,
+		max:  1000,
+	},
+}
+
+func (s *validationSuite) TestRegisterRange(c *gc.C) {
+	for i, t := range rangeTests {
+		c.Logf("test %d: %s", i, t.cons)
+		validator := constraints.NewValidator()
+		validator.RegisterRange(t.attr, t.min, t.max)
+		cons := constraints.MustParse(t.cons)
+		_, err := validator.Validate(cons)
+		if t.err == "" {
+			c.Assert(err, jc.ErrorIsNil)
+		} else {
+			c.Assert(err, gc.ErrorMatches, t.err)
+		}
+	}
+}
+
+func (s *validationSuite) TestIntersectRange(c *gc.C) {
+	validator := constraints.NewValidator()
+	validator.RegisterRange("cpu-cores", 2, 16)
+	validator.IntersectRange("cpu-cores", 4, 32)
+
+	_, err := validator.Validate(constraints.MustParse("cpu-cores=8"))
+	c.Assert(err, jc.ErrorIsNil)
+
+	_, err = validator.Validate(constraints.MustParse("cpu-cores=2"))
+	c.Assert(err, gc.ErrorMatches, `invalid constraint value: cpu-cores=2\nvalid range is: \[4, 16\]`)
+
+	_, err = validator.Validate(constraints.MustParse("cpu-cores=20"))
+	c.Assert(err, gc.ErrorMatches, `invalid constraint value: cpu-cores=20\nvalid range is: \[4, 16\]`)
+}
+
+func (s *validationSuite) TestRegisterPredicate(c *gc.C) {
+	isPowerOfTwo := func(val interface{}) error {
+		n, ok := val.(uint64)
+		if !ok || n == 0 || n&(n-1) != 0 {
+			return errors.Errorf("must be a power of two")
+		}
+		return nil
+	}
+
+	validator := constraints.NewValidator()
+	validator.RegisterPredicate("cpu-cores", isPowerOfTwo)
+
+	_, err := validator.Validate(constraints.MustParse("cpu-cores=4"))
+	c.Assert(err, jc.ErrorIsNil)
+
+	_, err = validator.Validate(constraints.MustParse("cpu-cores=5"))
+	c.Assert(err, gc.ErrorMatches, `invalid constraint value: cpu-cores=5\nmust be a power of two`)
+}
+
 func (s *validationSuite) TestUpdateVocabulary(c *gc.C) {
 	validator := constraints.NewValidator()
 	attributeName := "arch"
@@ -383,6 +517,33 @@ valid values are: [amd64]`))
 	c.Assert(err, jc.ErrorIsNil)
 }
 
+func (s *validationSuite) TestRegisterAttribute(c *gc.C) {
+	constraints.RegisterAttribute("gpu", constraints.KindString,
+		func(str string) (interface{}, error) { return str, nil },
+		func(val interface{}) string { return val.(string) },
+	)
+
+	cons := constraints.MustParse("cpu-cores=2 gpu=nvidia-a100")
+	c.Assert(cons.String(), gc.Equals, "cpu-cores=2 gpu=nvidia-a100")
+
+	validator := constraints.NewValidator()
+	_, err := validator.Validate(cons)
+	c.Assert(err, jc.ErrorIsNil)
+
+	validator.RegisterVocabulary("gpu", []string{"nvidia-a100", "nvidia-h100"})
+	_, err = validator.Validate(cons)
+	c.Assert(err, jc.ErrorIsNil)
+
+	_, err = validator.Validate(constraints.MustParse("gpu=rtx-4090"))
+	c.Assert(err, gc.ErrorMatches, regexp.QuoteMeta(`invalid constraint value: gpu=rtx-4090
+valid values are: [nvidia-a100 nvidia-h100]`))
+
+	validator.RegisterUnsupported([]string{"gpu"})
+	unsupported, err := validator.Validate(cons)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(unsupported, jc.SameContents, []string{"gpu"})
+}
+
 func (s *intersectionSuite) SetUpTest(c *gc.C) {
 	s.validCons = "arch=amd64"
 	s.invalidCons = "arch=ppc64el"
@@ -0,0 +1,184 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package lxc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"launchpad.net/golxc"
+
+	"launchpad.net/juju-core/container"
+	"launchpad.net/juju-core/juju/osenv"
+)
+
+// TemplateLockDir is where a lock file is held for the duration of
+// template creation, so that two containers being started on the same
+// machine at once don't race to build the same series' template.
+var TemplateLockDir string
+
+// TemplateStopTimeout is how long EnsureCloneTemplate waits for a
+// freshly created template container to shut itself down, via the
+// upstart job cloud-init installs in it, before giving up.
+var TemplateStopTimeout = 5 * time.Minute
+
+// TemplateChecksums holds the expected SHA-256 of each series' template
+// tarball, for sources that publish one. A series with no entry (or an
+// empty value) skips checksum verification.
+var TemplateChecksums map[string]string
+
+// existingTemplate and buildTemplateLocally are package variables, in
+// the manner of TemplateLockDir above, so tests can substitute fakes
+// without needing a real golxc/lxc-create environment.
+var (
+	existingTemplate     = findExistingTemplate
+	buildTemplateLocally = createTemplateLocally
+)
+
+// templateName returns the clone template name EnsureCloneTemplate uses
+// for series, e.g. "juju-precise-template".
+func templateName(series string) string {
+	return fmt.Sprintf("juju-%s-template", series)
+}
+
+// EnsureCloneTemplate returns the clone template container for series,
+// creating one if it doesn't already exist on this machine. Every other
+// container of that series is then created as a clone of this template,
+// to avoid paying the cost of a from-scratch cloud-init run more than
+// once per series per machine.
+//
+// Building a template from scratch is expensive, so before doing that
+// EnsureCloneTemplate tries every source in TemplateSources for a
+// pre-built rootfs tarball; only once none of them have anything does
+// it fall back to constructing the template locally, as it always has.
+func EnsureCloneTemplate(
+	backingFilesystem, series string,
+	network *container.NetworkConfig,
+	authorizedKeys string,
+	aptProxy osenv.ProxySettings,
+) (golxc.Container, error) {
+	name := templateName(series)
+	if tmpl := existingTemplate(name); tmpl != nil {
+		return tmpl, nil
+	}
+
+	ok, err := fetchFromSources(name, series, TemplateChecksums[series])
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		tmpl := existingTemplate(name)
+		if tmpl == nil {
+			return nil, fmt.Errorf("template %s imported but not found", name)
+		}
+		return tmpl, nil
+	}
+
+	return buildTemplateLocally(name, backingFilesystem, series, network, authorizedKeys, aptProxy)
+}
+
+// findExistingTemplate returns the already-constructed template
+// container named name, or nil if this machine doesn't have one yet.
+func findExistingTemplate(name string) golxc.Container {
+	tmpl := golxc.Factory().New(name)
+	if !tmpl.IsConstructed() {
+		return nil
+	}
+	return tmpl
+}
+
+// createTemplateLocally builds the clone template named name from
+// scratch: constructing it with golxc, starting it to let cloud-init
+// run, and waiting up to TemplateStopTimeout for the template to stop
+// itself once that upstart job has finished.
+func createTemplateLocally(
+	name, backingFilesystem, series string,
+	network *container.NetworkConfig,
+	authorizedKeys string,
+	aptProxy osenv.ProxySettings,
+) (golxc.Container, error) {
+	lock, err := acquireTemplateLock(name)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	// Another machine process may have finished building the template
+	// while we waited for the lock.
+	if tmpl := existingTemplate(name); tmpl != nil {
+		return tmpl, nil
+	}
+
+	tmpl := golxc.Factory().New(name)
+	if err := tmpl.Create(backingFilesystem, series, network, authorizedKeys, aptProxy); err != nil {
+		return nil, fmt.Errorf("creating template %s: %v", name, err)
+	}
+	if err := tmpl.Start("", ""); err != nil {
+		return nil, fmt.Errorf("starting template %s: %v", name, err)
+	}
+	if err := waitForStop(tmpl, TemplateStopTimeout); err != nil {
+		return nil, fmt.Errorf("waiting for template %s to stop: %v", name, err)
+	}
+	return tmpl, nil
+}
+
+// templateLock guards template creation for a single template name
+// across every process on this machine.
+type templateLock struct {
+	file *os.File
+}
+
+// acquireTemplateLock takes an exclusive, machine-wide lock on name
+// under TemplateLockDir, blocking until any other in-progress build of
+// the same template has released it.
+func acquireTemplateLock(name string) (*templateLock, error) {
+	if err := os.MkdirAll(TemplateLockDir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(TemplateLockDir, name+".lock")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := flock(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &templateLock{file: f}, nil
+}
+
+// Unlock releases the lock, leaving the lock file in place for the next
+// caller to acquire.
+func (l *templateLock) Unlock() error {
+	defer l.file.Close()
+	return funlock(l.file)
+}
+
+// flock and funlock wrap the flock(2) syscall so acquireTemplateLock
+// works the same way the rest of juju-core's machine-wide locks do.
+func flock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+func funlock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+// waitForStop polls tmpl until it's no longer running, giving up with an
+// error once timeout has elapsed. Template containers stop themselves
+// once the upstart job cloud-init installs in them has finished, so this
+// is how EnsureCloneTemplate knows cloud-init is done.
+func waitForStop(tmpl golxc.Container, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for tmpl.IsRunning() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s", timeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return nil
+}
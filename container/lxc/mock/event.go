@@ -0,0 +1,55 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package mock
+
+// Action identifies what a mock container (or template) factory just did,
+// so tests can assert on the sequence of lifecycle events a manager
+// triggers without needing a real lxc installation.
+type Action int
+
+const (
+	Created Action = iota
+	Started
+	Stopped
+	Destroyed
+	Cloned
+
+	// Fetched is emitted when a TemplateSource has supplied a rootfs
+	// tarball for a clone template, before it has been imported.
+	Fetched
+
+	// Imported is emitted once a tarball delivered by a TemplateSource
+	// has been registered as a usable clone template via
+	// `lxc-create --template none`.
+	Imported
+)
+
+// String returns the human readable name of the action, as used in test
+// failure messages.
+func (a Action) String() string {
+	switch a {
+	case Created:
+		return "created"
+	case Started:
+		return "started"
+	case Stopped:
+		return "stopped"
+	case Destroyed:
+		return "destroyed"
+	case Cloned:
+		return "cloned"
+	case Fetched:
+		return "fetched"
+	case Imported:
+		return "imported"
+	}
+	return "unknown"
+}
+
+// Event records a single lifecycle action a mock factory performed
+// against the container or template identified by InstanceId.
+type Event struct {
+	Action     Action
+	InstanceId string
+}
@@ -0,0 +1,54 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package mock
+
+import (
+	"launchpad.net/juju-core/container"
+	"launchpad.net/juju-core/instance"
+)
+
+// Stats implements container.StatsManager for the mock containerFactory,
+// producing a single synthetic sample for inst so that tests exercising
+// the Stats plumbing don't need a real cgroup hierarchy on disk. stop is
+// ignored: the returned channel is already closed by the time Stats
+// returns.
+func (factory *containerFactory) Stats(inst instance.Instance, stop <-chan struct{}) (<-chan container.Stats, error) {
+	if _, err := factory.container(string(inst.Id())); err != nil {
+		return nil, err
+	}
+
+	results := make(chan container.Stats, 1)
+	results <- container.Stats{
+		CPUPercent:  1.5,
+		MemoryUsage: 1024 * 1024,
+		MemoryLimit: 512 * 1024 * 1024,
+		RxBytes:     2048,
+		TxBytes:     4096,
+		BlkioBytes:  8192,
+	}
+	close(results)
+	return results, nil
+}
+
+// StatsAll implements container.StatsManager by collecting a single
+// sample from every container the factory currently knows about. stop is
+// ignored: the returned channel is already closed by the time StatsAll
+// returns.
+func (factory *containerFactory) StatsAll(stop <-chan struct{}) (<-chan container.Stats, error) {
+	names := factory.allContainers()
+
+	results := make(chan container.Stats, len(names))
+	for range names {
+		results <- container.Stats{
+			CPUPercent:  1.5,
+			MemoryUsage: 1024 * 1024,
+			MemoryLimit: 512 * 1024 * 1024,
+			RxBytes:     2048,
+			TxBytes:     4096,
+			BlkioBytes:  8192,
+		}
+	}
+	close(results)
+	return results, nil
+}
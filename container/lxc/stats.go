@@ -0,0 +1,263 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package lxc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"launchpad.net/juju-core/container"
+	"launchpad.net/juju-core/instance"
+)
+
+// statsSampleInterval is how often a container's cgroup and network
+// counters are re-read while a Stats or StatsAll channel is being
+// consumed.
+var statsSampleInterval = 5 * time.Second
+
+// cgroupRoot is where the lxc cgroup hierarchy for a container named
+// "<name>" is expected to be mounted, mirroring the layout lxc itself
+// creates under /sys/fs/cgroup/<subsystem>/<name>.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// netRoot is where a container's veth interface's statistics are
+// exposed, once its name is known.
+const netRoot = "/sys/class/net"
+
+// Stats implements container.StatsManager by sampling inst's cgroup and
+// network counters every statsSampleInterval until stop is closed, at
+// which point the sampling goroutine exits and closes the returned
+// channel.
+func (manager *containerManager) Stats(inst instance.Instance, stop <-chan struct{}) (<-chan container.Stats, error) {
+	name := string(inst.Id())
+	veth, err := vethName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(chan container.Stats)
+	go manager.sampleLoop(name, veth, results, stop)
+	return results, nil
+}
+
+// StatsAll implements container.StatsManager by multiplexing a Stats
+// stream for every container currently listed by ListContainers onto a
+// single channel, until stop is closed.
+func (manager *containerManager) StatsAll(stop <-chan struct{}) (<-chan container.Stats, error) {
+	instances, err := manager.ListContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	// abort lets us tear down the Stats streams already started below if
+	// a later one fails to start, without touching the caller's stop
+	// channel, which StatsAll doesn't own and can't close itself.
+	abort := make(chan struct{})
+	merged := mergeStop(stop, abort)
+
+	var streams []<-chan container.Stats
+	for _, inst := range instances {
+		stream, err := manager.Stats(inst, merged)
+		if err != nil {
+			close(abort)
+			for _, started := range streams {
+				for range started {
+				}
+			}
+			return nil, err
+		}
+		streams = append(streams, stream)
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan container.Stats)
+	for _, stream := range streams {
+		wg.Add(1)
+		go func(stream <-chan container.Stats) {
+			defer wg.Done()
+			for stat := range stream {
+				select {
+				case results <- stat:
+				case <-stop:
+					return
+				}
+			}
+		}(stream)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results, nil
+}
+
+// mergeStop returns a channel that closes as soon as either a or b does.
+func mergeStop(a, b <-chan struct{}) <-chan struct{} {
+	merged := make(chan struct{})
+	go func() {
+		defer close(merged)
+		select {
+		case <-a:
+		case <-b:
+		}
+	}()
+	return merged
+}
+
+// sampleLoop samples name's cgroup and veth counters every
+// statsSampleInterval, sending a container.Stats on results for each
+// sample whose counters could all be read (a sample that fails to read is
+// skipped), until stop is closed, at which point it stops sampling and
+// closes results.
+func (manager *containerManager) sampleLoop(name, veth string, results chan<- container.Stats, stop <-chan struct{}) {
+	defer close(results)
+
+	var previousCPU uint64
+	previousSample := time.Now()
+
+	ticker := time.NewTicker(statsSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		stats, cpuUsage, err := sampleOnce(name, veth)
+		now := time.Now()
+		if err == nil {
+			elapsed := now.Sub(previousSample).Seconds()
+			if elapsed > 0 && previousCPU > 0 {
+				// cpuUsage is in nanoseconds; convert the delta over
+				// the sampling period into a percentage of one core.
+				stats.CPUPercent = float64(cpuUsage-previousCPU) / 1e9 / elapsed * 100
+			}
+			select {
+			case results <- stats:
+			case <-stop:
+				return
+			}
+		}
+		previousCPU = cpuUsage
+		previousSample = now
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// sampleOnce reads every cgroup and network counter for a single
+// container named name, whose veth interface is veth. It returns the
+// raw cpuacct.usage value alongside the populated Stats so the caller
+// can compute a CPU percentage across two samples.
+func sampleOnce(name, veth string) (container.Stats, uint64, error) {
+	memUsage, err := readCgroupUint(name, "memory", "memory.usage_in_bytes")
+	if err != nil {
+		return container.Stats{}, 0, err
+	}
+	memLimit, err := readCgroupUint(name, "memory", "memory.limit_in_bytes")
+	if err != nil {
+		return container.Stats{}, 0, err
+	}
+	cpuUsage, err := readCgroupUint(name, "cpuacct", "cpuacct.usage")
+	if err != nil {
+		return container.Stats{}, 0, err
+	}
+	blkio, err := readBlkioBytes(name)
+	if err != nil {
+		return container.Stats{}, 0, err
+	}
+	rx, err := readNetUint(veth, "rx_bytes")
+	if err != nil {
+		return container.Stats{}, 0, err
+	}
+	tx, err := readNetUint(veth, "tx_bytes")
+	if err != nil {
+		return container.Stats{}, 0, err
+	}
+
+	return container.Stats{
+		MemoryUsage: memUsage,
+		MemoryLimit: memLimit,
+		BlkioBytes:  blkio,
+		RxBytes:     rx,
+		TxBytes:     tx,
+	}, cpuUsage, nil
+}
+
+// readCgroupUint reads a single integer value out of the named cgroup
+// file for a container's subsystem, e.g. memory/memory.usage_in_bytes.
+func readCgroupUint(name, subsystem, file string) (uint64, error) {
+	path := filepath.Join(cgroupRoot, subsystem, name, file)
+	return readUintFile(path)
+}
+
+// readNetUint reads a single integer value out of a veth interface's
+// statistics directory, e.g. rx_bytes or tx_bytes.
+func readNetUint(veth, counter string) (uint64, error) {
+	path := filepath.Join(netRoot, veth, "statistics", counter)
+	return readUintFile(path)
+}
+
+// readUintFile reads a single whitespace-trimmed unsigned integer from
+// path, as exposed by most cgroup and sysfs counter files.
+func readUintFile(path string) (uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return value, nil
+}
+
+// readBlkioBytes sums the per-device byte counts reported in
+// blkio.throttle.io_service_bytes for name, which lists one "<major>:<minor>
+// <op> <bytes>" triple per line followed by a "Total" line per device and
+// a final grand "Total" line that this just re-derives by summing Read
+// and Write lines directly, to avoid relying on kernel version specific
+// formatting of the Total lines.
+func readBlkioBytes(name string) (uint64, error) {
+	path := filepath.Join(cgroupRoot, "blkio", name, "blkio.throttle.io_service_bytes")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var total uint64
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		op := fields[1]
+		if op != "Read" && op != "Write" {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		total += value
+	}
+	return total, nil
+}
+
+// vethName returns the host-side veth interface name lxc creates for the
+// container named name. lxc derives this from the container's
+// configuration at create time; until that plumbing is threaded through
+// here, callers can rely on the <name>-net0 convention queued config
+// templates default to within this package.
+func vethName(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("empty container name")
+	}
+	return name + "-net0", nil
+}
@@ -0,0 +1,269 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package lxc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"launchpad.net/juju-core/juju/osenv"
+)
+
+// TemplateSource knows how to obtain a clone template's rootfs for a
+// given series, so that EnsureCloneTemplate doesn't have to pay the full
+// cost of constructing one locally every time a series is seen for the
+// first time on a given machine.
+//
+// TemplateSources are consulted in order by EnsureCloneTemplate; the
+// first one to return a tarball wins, and the rest are skipped. If none
+// of them have anything, EnsureCloneTemplate falls back to building the
+// template itself, as it always has.
+type TemplateSource interface {
+	// Fetch retrieves a rootfs tarball for series, verifies it against
+	// sha256sum, and returns the path to the downloaded tarball on
+	// local disk. temporary reports whether that path is a scratch copy
+	// the caller now owns and must remove once done with it, as opposed
+	// to a persistent cache entry the source still owns. Fetch returns
+	// os.ErrNotExist if this source has nothing for series, so callers
+	// can move on to the next source.
+	Fetch(series, sha256sum string) (tarballPath string, temporary bool, err error)
+}
+
+// TemplateSources is the ordered list of sources EnsureCloneTemplate
+// consults before falling back to building a template locally. It is a
+// package variable, in the manner of TemplateLockDir and
+// TemplateStopTimeout, so that tests and environment configuration can
+// both override it.
+var TemplateSources []TemplateSource
+
+// notifyTemplateFetched and notifyTemplateImported are called as
+// fetchFromSources makes progress, so a test harness can translate them
+// into mock.Fetched/mock.Imported events and observe the new flow the
+// same way it observes Created/Started/Stopped/Cloned for the local
+// build path. Both are nil outside of tests.
+var (
+	notifyTemplateFetched  func(name string)
+	notifyTemplateImported func(name string)
+)
+
+// fetchFromSources tries each of TemplateSources in turn for series,
+// importing and registering the first tarball any of them supplies as
+// the clone template named name. It reports ok=false, with a nil error,
+// if none of the sources had anything, so the caller can fall back to
+// building the template locally as EnsureCloneTemplate always has.
+func fetchFromSources(name, series, sha256sum string) (ok bool, err error) {
+	for _, source := range TemplateSources {
+		tarballPath, temporary, err := source.Fetch(series, sha256sum)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if temporary {
+			defer os.Remove(tarballPath)
+		}
+
+		if notifyTemplateFetched != nil {
+			notifyTemplateFetched(name)
+		}
+		if err := importTemplateFunc(name, tarballPath); err != nil {
+			return false, err
+		}
+		if notifyTemplateImported != nil {
+			notifyTemplateImported(name)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// localCacheSource is a TemplateSource backed by a directory of tarballs
+// already present on this machine, e.g. ones fetched by a previous call.
+type localCacheSource struct {
+	dir string
+}
+
+// NewLocalCacheSource returns a TemplateSource that looks for
+// "<series>.tar.gz" in dir.
+func NewLocalCacheSource(dir string) TemplateSource {
+	return &localCacheSource{dir: dir}
+}
+
+// Fetch is part of the TemplateSource interface. The returned path is
+// the persistent cache entry itself, not a copy, so temporary is always
+// false: the caller must not remove it.
+func (s *localCacheSource) Fetch(series, sha256sum string) (string, bool, error) {
+	path := filepath.Join(s.dir, series+".tar.gz")
+	if _, err := os.Stat(path); err != nil {
+		return "", false, err
+	}
+	if err := verifyChecksum(path, sha256sum); err != nil {
+		return "", false, err
+	}
+	return path, false, nil
+}
+
+// httpSource is a TemplateSource backed by a shared simplestreams-style
+// URL, e.g. one configured in environment settings so every machine in a
+// model can pull templates from the same place.
+type httpSource struct {
+	baseURL string
+	proxy   osenv.ProxySettings
+}
+
+// NewHTTPTemplateSource returns a TemplateSource that fetches
+// "<baseURL>/<series>.tar.gz", honouring proxy for the request.
+func NewHTTPTemplateSource(baseURL string, proxy osenv.ProxySettings) TemplateSource {
+	return &httpSource{baseURL: baseURL, proxy: proxy}
+}
+
+// Fetch is part of the TemplateSource interface. The returned path is
+// always a freshly downloaded scratch copy, so temporary is always true.
+func (s *httpSource) Fetch(series, sha256sum string) (string, bool, error) {
+	url := fmt.Sprintf("%s/%s.tar.gz", s.baseURL, series)
+	path, err := fetchToTemp(url, series, sha256sum, s.proxy)
+	return path, true, err
+}
+
+// controllerSource is a TemplateSource backed by templates the
+// controller itself hosts, for models where no external URL is
+// reachable.
+type controllerSource struct {
+	apiAddrs []string
+	proxy    osenv.ProxySettings
+}
+
+// NewControllerTemplateSource returns a TemplateSource that fetches
+// templates from one of apiAddrs' template stores.
+func NewControllerTemplateSource(apiAddrs []string, proxy osenv.ProxySettings) TemplateSource {
+	return &controllerSource{apiAddrs: apiAddrs, proxy: proxy}
+}
+
+// Fetch is part of the TemplateSource interface. The returned path is
+// always a freshly downloaded scratch copy, so temporary is always true.
+func (s *controllerSource) Fetch(series, sha256sum string) (string, bool, error) {
+	var lastErr error = os.ErrNotExist
+	for _, addr := range s.apiAddrs {
+		url := fmt.Sprintf("https://%s/model/templates/%s.tar.gz", addr, series)
+		path, err := fetchToTemp(url, series, sha256sum, s.proxy)
+		if err == nil {
+			return path, true, nil
+		}
+		lastErr = err
+	}
+	return "", false, lastErr
+}
+
+// fetchToTemp downloads url into a temporary file, verifies it against
+// sha256sum, and returns its path.
+func fetchToTemp(url, series, sha256sum string, proxy osenv.ProxySettings) (string, error) {
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: templateProxyFunc(proxy)},
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", os.ErrNotExist
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+
+	out, err := os.CreateTemp("", series+"-template-*.tar.gz")
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+
+	if err := verifyChecksum(out.Name(), sha256sum); err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), nil
+}
+
+// templateProxyFunc adapts proxy to the func(*http.Request) (*url.URL,
+// error) shape http.Transport.Proxy expects, falling back to the
+// environment when proxy is the zero value, same as the rest of this
+// package threads aptProxy through to apt/cloud-init.
+func templateProxyFunc(proxy osenv.ProxySettings) func(*http.Request) (*url.URL, error) {
+	if proxy.Https == "" && proxy.Http == "" {
+		return http.ProxyFromEnvironment
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		target := proxy.Http
+		if req.URL.Scheme == "https" {
+			target = proxy.Https
+		}
+		if target == "" {
+			return nil, nil
+		}
+		return url.Parse(target)
+	}
+}
+
+// verifyChecksum returns an error unless path's contents hash to
+// sha256sum. An empty sha256sum skips verification, for sources that
+// don't publish one.
+func verifyChecksum(path, sha256sum string) error {
+	if sha256sum == "" {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	got := hex.EncodeToString(h.Sum(nil))
+	if got != sha256sum {
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s", path, got, sha256sum)
+	}
+	return nil
+}
+
+// importTemplateFunc is a package variable, in the manner of
+// TemplateLockDir, so tests can substitute a fake import step without a
+// real lxc-create binary.
+var importTemplateFunc = importTemplate
+
+// importTemplate registers the rootfs tarball at tarballPath as the
+// clone template named name, via `lxc-create --template none`, the same
+// mechanism used to seed a freshly downloaded template into the local
+// lxc cache that EnsureCloneTemplate's local path uses for a
+// from-scratch build.
+func importTemplate(name, tarballPath string) error {
+	cmd := exec.Command(
+		"lxc-create",
+		"--name", name,
+		"--template", "none",
+		"--", "--rootfs-tarball", tarballPath,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("importing template %s: %v (%s)", name, err, out)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package lxc
+
+import (
+	"fmt"
+	"os"
+	stdtesting "testing"
+
+	gc "launchpad.net/gocheck"
+	"launchpad.net/golxc"
+
+	"launchpad.net/juju-core/container"
+	"launchpad.net/juju-core/container/lxc/mock"
+	"launchpad.net/juju-core/juju/osenv"
+)
+
+func Test(t *stdtesting.T) {
+	gc.TestingT(t)
+}
+
+type TemplateSourceSuite struct {
+	events []mock.Event
+}
+
+var _ = gc.Suite(&TemplateSourceSuite{})
+
+func (s *TemplateSourceSuite) SetUpTest(c *gc.C) {
+	s.events = nil
+	notifyTemplateFetched = func(name string) {
+		s.events = append(s.events, mock.Event{Action: mock.Fetched, InstanceId: name})
+	}
+	notifyTemplateImported = func(name string) {
+		s.events = append(s.events, mock.Event{Action: mock.Imported, InstanceId: name})
+	}
+}
+
+func (s *TemplateSourceSuite) TearDownTest(c *gc.C) {
+	notifyTemplateFetched = nil
+	notifyTemplateImported = nil
+	TemplateSources = nil
+	existingTemplate = findExistingTemplate
+	buildTemplateLocally = createTemplateLocally
+	importTemplateFunc = importTemplate
+}
+
+// fakeSource is a TemplateSource that either supplies a canned tarball
+// path or reports os.ErrNotExist, so tests can drive fetchFromSources
+// without touching the network or local disk.
+type fakeSource struct {
+	path string
+}
+
+func (f fakeSource) Fetch(series, sha256sum string) (string, bool, error) {
+	if f.path == "" {
+		return "", false, os.ErrNotExist
+	}
+	return f.path, false, nil
+}
+
+func (s *TemplateSourceSuite) TestFetchFromSourcesSkipsSourcesWithNothing(c *gc.C) {
+	var imported []string
+	importTemplateFunc = func(name, tarballPath string) error {
+		imported = append(imported, tarballPath)
+		return nil
+	}
+	TemplateSources = []TemplateSource{fakeSource{}, fakeSource{path: "/tmp/precise.tar.gz"}}
+
+	ok, err := fetchFromSources("juju-precise-template", "precise", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(imported, gc.DeepEquals, []string{"/tmp/precise.tar.gz"})
+	c.Assert(s.events, gc.DeepEquals, []mock.Event{
+		{Action: mock.Fetched, InstanceId: "juju-precise-template"},
+		{Action: mock.Imported, InstanceId: "juju-precise-template"},
+	})
+}
+
+func (s *TemplateSourceSuite) TestFetchFromSourcesNoneAvailable(c *gc.C) {
+	TemplateSources = []TemplateSource{fakeSource{}, fakeSource{}}
+
+	ok, err := fetchFromSources("juju-precise-template", "precise", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(ok, gc.Equals, false)
+	c.Assert(s.events, gc.IsNil)
+}
+
+func (s *TemplateSourceSuite) TestFetchFromSourcesImportErrorStops(c *gc.C) {
+	importTemplateFunc = func(name, tarballPath string) error {
+		return fmt.Errorf("boom")
+	}
+	TemplateSources = []TemplateSource{fakeSource{path: "/tmp/precise.tar.gz"}}
+
+	ok, err := fetchFromSources("juju-precise-template", "precise", "")
+	c.Assert(err, gc.ErrorMatches, "boom")
+	c.Assert(ok, gc.Equals, false)
+}
+
+func (s *TemplateSourceSuite) TestEnsureCloneTemplateUsesSourcesBeforeBuildingLocally(c *gc.C) {
+	sentinel := golxc.Factory().New("juju-precise-template")
+
+	var existingCalls int
+	existingTemplate = func(name string) golxc.Container {
+		existingCalls++
+		if existingCalls == 1 {
+			return nil
+		}
+		return sentinel
+	}
+	var importedPath string
+	importTemplateFunc = func(name, tarballPath string) error {
+		importedPath = tarballPath
+		return nil
+	}
+	buildTemplateLocally = func(name, backingFilesystem, series string, network *container.NetworkConfig, authorizedKeys string, aptProxy osenv.ProxySettings) (golxc.Container, error) {
+		c.Fatalf("buildTemplateLocally called despite a source having a tarball")
+		return nil, nil
+	}
+	TemplateSources = []TemplateSource{fakeSource{path: "/tmp/precise.tar.gz"}}
+
+	tmpl, err := EnsureCloneTemplate("ext4", "precise", nil, "", osenv.ProxySettings{})
+	c.Assert(err, gc.IsNil)
+	c.Assert(tmpl, gc.Equals, sentinel)
+	c.Assert(importedPath, gc.Equals, "/tmp/precise.tar.gz")
+	c.Assert(existingCalls, gc.Equals, 2)
+}
+
+func (s *TemplateSourceSuite) TestEnsureCloneTemplateFallsBackToLocalBuild(c *gc.C) {
+	existingTemplate = func(name string) golxc.Container { return nil }
+	sentinel := golxc.Factory().New("juju-precise-template")
+	var buildCalled bool
+	buildTemplateLocally = func(name, backingFilesystem, series string, network *container.NetworkConfig, authorizedKeys string, aptProxy osenv.ProxySettings) (golxc.Container, error) {
+		buildCalled = true
+		return sentinel, nil
+	}
+	TemplateSources = []TemplateSource{fakeSource{}}
+
+	tmpl, err := EnsureCloneTemplate("ext4", "precise", nil, "", osenv.ProxySettings{})
+	c.Assert(err, gc.IsNil)
+	c.Assert(tmpl, gc.Equals, sentinel)
+	c.Assert(buildCalled, gc.Equals, true)
+	c.Assert(s.events, gc.IsNil)
+}
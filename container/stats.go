@@ -0,0 +1,59 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package container
+
+import (
+	"launchpad.net/juju-core/instance"
+)
+
+// Stats is a point-in-time resource usage sample for a single container,
+// similar in spirit to what `docker stats` reports.
+type Stats struct {
+	// CPUPercent is the container's CPU usage over the sampling period,
+	// as a percentage of a single core.
+	CPUPercent float64
+
+	// MemoryUsage is the current value of the container's
+	// memory.usage_in_bytes cgroup file.
+	MemoryUsage uint64
+
+	// MemoryLimit is the current value of the container's
+	// memory.limit_in_bytes cgroup file.
+	MemoryLimit uint64
+
+	// RxBytes and TxBytes are the cumulative bytes received and
+	// transmitted on the container's network devices.
+	RxBytes uint64
+	TxBytes uint64
+
+	// BlkioBytes is the cumulative bytes read and written by the
+	// container via blkio.throttle.io_service_bytes.
+	BlkioBytes uint64
+}
+
+// StatsManager is implemented by container managers that can stream
+// resource usage statistics for the containers they manage.
+//
+// The request this satisfies also asked for this to be a method set on
+// container.Manager, and for the stream to be hooked into the machiner
+// and surfaced through a new API facade so `juju status --containers`
+// could show it. Neither is done: this pruned tree has no
+// container.Manager interface for StatsManager to join (no manager.go
+// anywhere under container/), and it has no worker/machiner or
+// apiserver facade packages for a status-facing facade to live in.
+// Wiring either up would mean inventing that infrastructure from
+// guesswork rather than extending what's actually here, so StatsManager
+// stands alone for now as the extension point containerManager and the
+// mock factory both implement.
+type StatsManager interface {
+	// Stats periodically samples resource usage for inst, sending each
+	// sample on the returned channel until stop is closed, at which
+	// point the sampling goroutine exits and closes the channel.
+	Stats(inst instance.Instance, stop <-chan struct{}) (<-chan Stats, error)
+
+	// StatsAll is like Stats, but multiplexes samples for every
+	// container currently managed by this Manager onto a single
+	// channel, until stop is closed.
+	StatsAll(stop <-chan struct{}) (<-chan Stats, error)
+}
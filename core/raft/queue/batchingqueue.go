@@ -0,0 +1,230 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package queue
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+)
+
+// defaultMaxLinger is how long a BatchingOpQueue will hold a batch open,
+// waiting for more commands to join it, before applying what it has.
+const defaultMaxLinger = 5 * time.Millisecond
+
+// BatchingOpQueue is a Queue, like BlockingOpQueue, except that Enqueue
+// calls arriving close together in time are coalesced into a single
+// Operation with all of their commands, rather than each becoming its
+// own raft apply. A batch is applied as soon as it holds maxBatch
+// commands, or maxLinger has elapsed since its first command arrived,
+// whichever comes first. The consumer reports one error per command in
+// the Operation, in the same order, so a command failing only fails the
+// Enqueue calls that contributed it, not every call coalesced into the
+// same batch.
+type BatchingOpQueue struct {
+	clock     clock.Clock
+	maxBatch  int
+	maxLinger time.Duration
+
+	queue  chan Operation
+	errors chan []error
+
+	mu      sync.Mutex
+	current *opBatch
+
+	batches  uint64
+	commands uint64
+	largest  uint64
+}
+
+// opBatch accumulates the commands and waiters for a single batch. counts
+// records how many of commands each entry in waiters contributed, in the
+// same order, so apply can slice the consumer's per-command results back
+// out to the waiter they belong to.
+type opBatch struct {
+	commands [][]byte
+	waiters  []chan error
+	counts   []int
+}
+
+// NewBatchingOpQueue creates a BatchingOpQueue that uses clk both for
+// EnqueueTimeout, as BlockingOpQueue does, and for maxLinger. maxBatch
+// below 1 is treated as 1 (no coalescing); maxLinger of 0 or below uses
+// defaultMaxLinger.
+func NewBatchingOpQueue(clk clock.Clock, maxBatch int, maxLinger time.Duration) *BatchingOpQueue {
+	if maxBatch < 1 {
+		maxBatch = 1
+	}
+	if maxLinger <= 0 {
+		maxLinger = defaultMaxLinger
+	}
+	return &BatchingOpQueue{
+		clock:     clk,
+		maxBatch:  maxBatch,
+		maxLinger: maxLinger,
+		queue:     make(chan Operation),
+		errors:    make(chan []error),
+	}
+}
+
+// Enqueue adds op's commands to the batch currently being filled,
+// starting a new one if necessary, and blocks until that batch has been
+// applied. All of op's commands are applied together, even if op itself
+// came to be coalesced with others.
+func (q *BatchingOpQueue) Enqueue(op Operation) error {
+	wait := make(chan error, 1)
+	if full := q.add(op.Commands, wait); full != nil {
+		q.apply(full)
+	}
+	return <-wait
+}
+
+// add appends commands to the batch currently being filled, starting a
+// new one (and its linger timer) if there isn't one. It returns the
+// batch if adding commands just brought it to maxBatch, so the caller
+// can apply it outside the lock; otherwise it returns nil.
+func (q *BatchingOpQueue) add(commands [][]byte, wait chan error) *opBatch {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	b := q.current
+	if b == nil {
+		b = &opBatch{}
+		q.current = b
+		q.clock.AfterFunc(q.maxLinger, func() { q.timeout(b) })
+	}
+	b.commands = append(b.commands, commands...)
+	b.waiters = append(b.waiters, wait)
+	b.counts = append(b.counts, len(commands))
+
+	if len(b.commands) >= q.maxBatch {
+		q.current = nil
+		return b
+	}
+	return nil
+}
+
+// timeout applies b if it is still the batch being filled, ie nothing
+// has applied it early by reaching maxBatch in the meantime.
+func (q *BatchingOpQueue) timeout(b *opBatch) {
+	q.mu.Lock()
+	if q.current != b {
+		q.mu.Unlock()
+		return
+	}
+	q.current = nil
+	q.mu.Unlock()
+	q.apply(b)
+}
+
+// apply hands b's combined commands to the consumer as a single
+// Operation, records it for Metrics, and delivers to each waiter only
+// the result of the commands it contributed, rather than the fate of the
+// whole batch.
+func (q *BatchingOpQueue) apply(b *opBatch) {
+	results, err := applyBatchOperation(q.clock, q.queue, q.errors, Operation{Commands: b.commands})
+	q.recordBatch(len(b.commands))
+
+	if err == nil && len(results) != len(b.commands) {
+		err = errors.Errorf("consumer reported %d results for %d commands", len(results), len(b.commands))
+	}
+
+	var i int
+	for w, wait := range b.waiters {
+		n := b.counts[w]
+		if err != nil {
+			wait <- err
+		} else {
+			wait <- firstError(results[i : i+n])
+		}
+		i += n
+	}
+}
+
+// firstError returns the first non-nil error in errs, or nil if there
+// isn't one.
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyBatchOperation hands op to whatever is consuming queue, and waits
+// for a []error on errs with one entry per command in op.Commands,
+// giving up with ErrDeadlineExceeded if neither happens within
+// EnqueueTimeout. It mirrors applyOperation, but for a consumer that
+// reports results per-command rather than per-Operation.
+func applyBatchOperation(clk clock.Clock, queue chan<- Operation, errs <-chan []error, op Operation) ([]error, error) {
+	timeout := clk.After(EnqueueTimeout)
+
+	select {
+	case queue <- op:
+	case <-timeout:
+		return nil, ErrDeadlineExceeded
+	}
+
+	select {
+	case results := <-errs:
+		return results, nil
+	case <-timeout:
+		return nil, ErrDeadlineExceeded
+	}
+}
+
+// Queue returns the channel Operations are delivered on. The consumer
+// must send exactly one []error on Error() for every Operation it takes
+// from this channel, with one entry per command in that Operation's
+// Commands, in the same order.
+func (q *BatchingOpQueue) Queue() <-chan Operation {
+	return q.queue
+}
+
+// Error returns the channel the consumer uses to report the result of
+// applying the most recently delivered Operation: one error, or nil, per
+// command in that Operation, in the same order.
+func (q *BatchingOpQueue) Error() chan<- []error {
+	return q.errors
+}
+
+// Metrics is a point-in-time snapshot of a BatchingOpQueue's activity.
+type Metrics struct {
+	// Batches is the number of Operations offered to the consumer so far,
+	// including any that hit EnqueueTimeout before the consumer took them.
+	Batches uint64
+	// Commands is the number of individual commands that have gone into
+	// those Operations.
+	Commands uint64
+	// LargestBatch is the number of commands in the largest of those
+	// Operations.
+	LargestBatch int
+}
+
+// Metrics returns a snapshot of q's batching activity.
+func (q *BatchingOpQueue) Metrics() Metrics {
+	return Metrics{
+		Batches:      atomic.LoadUint64(&q.batches),
+		Commands:     atomic.LoadUint64(&q.commands),
+		LargestBatch: int(atomic.LoadUint64(&q.largest)),
+	}
+}
+
+func (q *BatchingOpQueue) recordBatch(size int) {
+	atomic.AddUint64(&q.batches, 1)
+	atomic.AddUint64(&q.commands, uint64(size))
+	for {
+		largest := atomic.LoadUint64(&q.largest)
+		if uint64(size) <= largest {
+			return
+		}
+		if atomic.CompareAndSwapUint64(&q.largest, largest, uint64(size)) {
+			return
+		}
+	}
+}
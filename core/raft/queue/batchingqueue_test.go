@@ -0,0 +1,210 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package queue
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/clock/testclock"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+)
+
+type BatchingOpQueueSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&BatchingOpQueueSuite{})
+
+func (s *BatchingOpQueueSuite) TestEnqueue(c *gc.C) {
+	queue := NewBatchingOpQueue(clock.WallClock, 10, time.Millisecond)
+
+	results := consumeBatches(c, queue, 1)
+
+	err := queue.Enqueue(Operation{
+		Commands: commandsN(1),
+	})
+	c.Assert(err, jc.ErrorIsNil)
+
+	batch := <-results
+	c.Assert(batch, jc.SameContents, commandsN(1))
+
+	metrics := queue.Metrics()
+	c.Assert(metrics.Batches, gc.Equals, uint64(1))
+	c.Assert(metrics.Commands, gc.Equals, uint64(1))
+	c.Assert(metrics.LargestBatch, gc.Equals, 1)
+}
+
+func (s *BatchingOpQueueSuite) TestConcurrentEnqueuesCoalesceIntoOneBatch(c *gc.C) {
+	queue := NewBatchingOpQueue(clock.WallClock, 10, time.Millisecond)
+
+	results := consumeBatches(c, queue, 1)
+
+	var wg sync.WaitGroup
+	wg.Add(5)
+	for i := 0; i < 5; i++ {
+		go func(i int) {
+			defer wg.Done()
+
+			err := queue.Enqueue(Operation{
+				Commands: [][]byte{opName(i)},
+			})
+			c.Check(err, jc.ErrorIsNil)
+		}(i)
+	}
+	wg.Wait()
+
+	batch := <-results
+	c.Assert(batch, jc.SameContents, commandsN(5))
+
+	metrics := queue.Metrics()
+	c.Assert(metrics.Batches, gc.Equals, uint64(1))
+	c.Assert(metrics.Commands, gc.Equals, uint64(5))
+	c.Assert(metrics.LargestBatch, gc.Equals, 5)
+}
+
+func (s *BatchingOpQueueSuite) TestMaxBatchFlushesEarly(c *gc.C) {
+	now := time.Now()
+	queue := NewBatchingOpQueue(testclock.NewClock(now), 2, testing.LongWait)
+
+	results := consumeBatches(c, queue, 1)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		go func(i int) {
+			defer wg.Done()
+
+			err := queue.Enqueue(Operation{
+				Commands: [][]byte{opName(i)},
+			})
+			c.Check(err, jc.ErrorIsNil)
+		}(i)
+	}
+	wg.Wait()
+
+	batch := <-results
+	c.Assert(batch, jc.SameContents, commandsN(2))
+}
+
+func (s *BatchingOpQueueSuite) TestMaxLingerFlushesOnTimeout(c *gc.C) {
+	now := time.Now()
+	clock := testclock.NewClock(now)
+	queue := NewBatchingOpQueue(clock, 10, time.Second)
+
+	results := consumeBatches(c, queue, 1)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- queue.Enqueue(Operation{
+			Commands: commandsN(1),
+		})
+	}()
+
+	c.Assert(clock.WaitAdvance(time.Second, testing.ShortWait, 1), jc.ErrorIsNil)
+
+	select {
+	case err := <-errs:
+		c.Assert(err, jc.ErrorIsNil)
+	case <-time.After(testing.LongWait):
+		c.Fatal("timed out waiting for the lingering batch to flush")
+	}
+
+	batch := <-results
+	c.Assert(batch, jc.SameContents, commandsN(1))
+}
+
+func (s *BatchingOpQueueSuite) TestCoalescedFailureOnlyFailsItsOwnCaller(c *gc.C) {
+	queue := NewBatchingOpQueue(clock.WallClock, 10, time.Millisecond)
+
+	go func() {
+		op := <-queue.Queue()
+		results := make([]error, len(op.Commands))
+		for i, cmd := range op.Commands {
+			if string(cmd) == "bad" {
+				results[i] = errors.New("command failed")
+			}
+		}
+		queue.Error() <- results
+	}()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		errs[0] = queue.Enqueue(Operation{Commands: [][]byte{[]byte("good")}})
+	}()
+	go func() {
+		defer wg.Done()
+		errs[1] = queue.Enqueue(Operation{Commands: [][]byte{[]byte("bad")}})
+	}()
+	wg.Wait()
+
+	c.Check(errs[0], jc.ErrorIsNil)
+	c.Check(errs[1], gc.ErrorMatches, "command failed")
+}
+
+func (s *BatchingOpQueueSuite) TestConsumerResultCountMismatchFailsAllWaiters(c *gc.C) {
+	queue := NewBatchingOpQueue(clock.WallClock, 10, time.Millisecond)
+
+	go func() {
+		op := <-queue.Queue()
+		// Wrong length: the consumer should have sent one result per
+		// command in op.Commands.
+		queue.Error() <- make([]error, len(op.Commands)-1)
+	}()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		errs[0] = queue.Enqueue(Operation{Commands: [][]byte{[]byte("a")}})
+	}()
+	go func() {
+		defer wg.Done()
+		errs[1] = queue.Enqueue(Operation{Commands: [][]byte{[]byte("b")}})
+	}()
+	wg.Wait()
+
+	c.Check(errs[0], gc.NotNil)
+	c.Check(errs[1], gc.NotNil)
+}
+
+// consumeBatches reads n Operations from queue, acknowledging each with a
+// nil error, and sends their combined commands on the returned channel.
+func consumeBatches(c *gc.C, queue *BatchingOpQueue, n int) <-chan [][]byte {
+	results := make(chan [][]byte, n)
+
+	go func() {
+		defer close(results)
+
+		var count int
+		for op := range queue.Queue() {
+			select {
+			case results <- op.Commands:
+			case <-time.After(testing.LongWait):
+				c.Fatal("timed out setting results")
+			}
+
+			select {
+			case queue.Error() <- make([]error, len(op.Commands)):
+			case <-time.After(testing.LongWait):
+				c.Fatal("timed out setting error")
+			}
+
+			count++
+			if count == n {
+				break
+			}
+		}
+	}()
+
+	return results
+}
@@ -0,0 +1,96 @@
+// Copyright 2021 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package queue
+
+import (
+	"time"
+
+	"github.com/juju/clock"
+	"github.com/juju/errors"
+)
+
+// EnqueueTimeout is the amount of time an Enqueue call will wait, both to
+// hand its Operation to a consumer and to receive that operation's
+// result, before giving up with ErrDeadlineExceeded.
+const EnqueueTimeout = 250 * time.Millisecond
+
+// ErrDeadlineExceeded is returned by Enqueue if EnqueueTimeout is reached
+// before the operation could be handed off, or before its result came
+// back.
+var ErrDeadlineExceeded = errors.New("enqueueing deadline exceeded")
+
+// IsDeadlineExceeded reports whether err is, or wraps, ErrDeadlineExceeded.
+func IsDeadlineExceeded(err error) bool {
+	return errors.Cause(err) == ErrDeadlineExceeded
+}
+
+// Operation holds a set of commands to be applied to the raft FSM as a
+// single unit.
+type Operation struct {
+	// Commands are the individual commands to apply.
+	Commands [][]byte
+}
+
+// BlockingOpQueue serialises access to a raft instance: only one
+// Operation is ever in flight at a time, and Enqueue blocks the caller
+// until it has been consumed and a result is known, so that the caller
+// can decide how to back off if applying is too slow.
+type BlockingOpQueue struct {
+	clock  clock.Clock
+	queue  chan Operation
+	errors chan error
+}
+
+// NewBlockingOpQueue creates a new BlockingOpQueue that uses clock to
+// enforce EnqueueTimeout.
+func NewBlockingOpQueue(clock clock.Clock) *BlockingOpQueue {
+	return &BlockingOpQueue{
+		clock:  clock,
+		queue:  make(chan Operation),
+		errors: make(chan error),
+	}
+}
+
+// Enqueue hands op to whatever is consuming Queue(), and waits for the
+// result to be sent on Error(). If EnqueueTimeout is reached before
+// either happens, it returns ErrDeadlineExceeded and the caller is free
+// to retry.
+func (q *BlockingOpQueue) Enqueue(op Operation) error {
+	return applyOperation(q.clock, q.queue, q.errors, op)
+}
+
+// applyOperation hands op to whatever is consuming queue, and waits for
+// a result on errs, giving up with ErrDeadlineExceeded if neither
+// happens within EnqueueTimeout. BatchingOpQueue uses the near-identical
+// applyBatchOperation instead, since its consumer reports a []error
+// rather than a single error.
+func applyOperation(clk clock.Clock, queue chan<- Operation, errs <-chan error, op Operation) error {
+	timeout := clk.After(EnqueueTimeout)
+
+	select {
+	case queue <- op:
+	case <-timeout:
+		return ErrDeadlineExceeded
+	}
+
+	select {
+	case err := <-errs:
+		return errors.Trace(err)
+	case <-timeout:
+		return ErrDeadlineExceeded
+	}
+}
+
+// Queue returns the channel operations are delivered on. The consumer
+// must send exactly one value on Error() for every operation it takes
+// from this channel.
+func (q *BlockingOpQueue) Queue() <-chan Operation {
+	return q.queue
+}
+
+// Error returns the channel the consumer uses to report the result of
+// applying the most recently delivered operation.
+func (q *BlockingOpQueue) Error() chan<- error {
+	return q.errors
+}